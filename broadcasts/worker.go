@@ -21,8 +21,9 @@ const (
 )
 
 func init() {
-	mailroom.AddTaskFunction(queue.SendBroadcast, handleSendBroadcast)
-	mailroom.AddTaskFunction(queue.SendBroadcastBatch, handleSendBroadcastBatch)
+	// these aren't retried as messages may have already been created and queued for some contacts when they fail
+	mailroom.AddTaskFunction(queue.SendBroadcast, handleSendBroadcast, nil)
+	mailroom.AddTaskFunction(queue.SendBroadcastBatch, handleSendBroadcastBatch, nil)
 }
 
 // handleSendBroadcast creates all the batches of contacts that need to be sent to
@@ -40,11 +41,24 @@ func handleSendBroadcast(ctx context.Context, mr *mailroom.Mailroom, task *queue
 		return errors.Wrapf(err, "error unmarshalling broadcast: %s", string(task.Task))
 	}
 
-	return CreateBroadcastBatches(ctx, mr.DB, mr.RP, broadcast)
+	// if this broadcast was cancelled before we got to it, there's nothing to do
+	rc := mr.RP.Get()
+	cancelled, err := models.IsBroadcastCancelled(rc, broadcast.BroadcastID())
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		logrus.WithField("broadcast_id", broadcast.BroadcastID()).Info("skipping cancelled broadcast")
+		return nil
+	}
+
+	return CreateBroadcastBatches(ctx, mr.DB, mr.Queue, broadcast)
 }
 
-// CreateBroadcastBatches takes our master broadcast and creates batches of broadcast sends for all the unique contacts
-func CreateBroadcastBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, bcast *models.Broadcast) error {
+// CreateBroadcastBatches takes our master broadcast and creates batches of broadcast sends for all the unique contacts,
+// queuing them to the passed in queue backend
+func CreateBroadcastBatches(ctx context.Context, db *sqlx.DB, queues queue.Backend, bcast *models.Broadcast) error {
 	// we are building a set of contact ids, start with the explicit ones
 	contactIDs := make(map[models.ContactID]bool)
 	for _, id := range bcast.ContactIDs() {
@@ -91,9 +105,6 @@ func CreateBroadcastBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, bc
 		urnContacts[id] = u
 	}
 
-	rc := rp.Get()
-	defer rc.Close()
-
 	contacts := make([]models.ContactID, 0, 100)
 
 	// utility functions for queueing the current set of contacts
@@ -113,7 +124,7 @@ func CreateBroadcastBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, bc
 			batch.SetURNs(urnContacts)
 		}
 
-		err = queue.AddTask(rc, q, queue.SendBroadcastBatch, int(bcast.OrgID()), batch, queue.DefaultPriority)
+		err = queues.AddTask(q, queue.SendBroadcastBatch, int(bcast.OrgID()), batch, queue.DefaultPriority)
 		if err != nil {
 			logrus.WithError(err).Error("error while queuing broadcast batch")
 		}
@@ -149,6 +160,18 @@ func handleSendBroadcastBatch(ctx context.Context, mr *mailroom.Mailroom, task *
 		return errors.Wrapf(err, "error unmarshalling broadcast: %s", string(task.Task))
 	}
 
+	// if our broadcast has been cancelled, skip this batch
+	rc := mr.RP.Get()
+	cancelled, err := models.IsBroadcastCancelled(rc, broadcast.BroadcastID())
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		logrus.WithField("broadcast_id", broadcast.BroadcastID()).Info("skipping batch for cancelled broadcast")
+		return nil
+	}
+
 	// try to send the batch
 	return SendBroadcastBatch(ctx, mr.DB, mr.RP, broadcast)
 }
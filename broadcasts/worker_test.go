@@ -86,7 +86,7 @@ func TestBroadcastEvents(t *testing.T) {
 		bcast, err := models.NewBroadcastFromEvent(ctx, db, org, event)
 		assert.NoError(t, err)
 
-		err = CreateBroadcastBatches(ctx, db, rp, bcast)
+		err = CreateBroadcastBatches(ctx, db, queue.NewRedisBackend(rp), bcast)
 		assert.NoError(t, err)
 
 		// pop all our tasks and execute them
@@ -197,7 +197,7 @@ func TestBroadcastTask(t *testing.T) {
 	for i, tc := range tcs {
 		// handle our start task
 		bcast := models.NewBroadcast(org.OrgID(), tc.BroadcastID, tc.Translations, tc.TemplateState, tc.BaseLanguage, tc.URNs, tc.ContactIDs, tc.GroupIDs)
-		err = CreateBroadcastBatches(ctx, db, rp, bcast)
+		err = CreateBroadcastBatches(ctx, db, queue.NewRedisBackend(rp), bcast)
 		assert.NoError(t, err)
 
 		// pop all our tasks and execute them
@@ -25,7 +25,7 @@ func TestCampaigns(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 	// schedule our campaign to be started
-	err := fireCampaignEvents(ctx, db, rp, campaignsLock, "lock")
+	err := fireCampaignEvents(ctx, db, rp, queue.NewRedisBackend(rp), campaignsLock, "lock")
 	assert.NoError(t, err)
 
 	// then actually work on the event
@@ -57,7 +57,7 @@ func TestIVRCampaigns(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 	// schedule our campaign to be started
-	err := fireCampaignEvents(ctx, db, rp, campaignsLock, "lock")
+	err := fireCampaignEvents(ctx, db, rp, queue.NewRedisBackend(rp), campaignsLock, "lock")
 	assert.NoError(t, err)
 
 	// then actually work on the event
@@ -11,7 +11,7 @@ import (
 	"github.com/nyaruka/librato"
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/cron"
-	"github.com/nyaruka/mailroom/marker"
+	"github.com/nyaruka/mailroom/idempotency"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/nyaruka/mailroom/queue"
 	"github.com/pkg/errors"
@@ -22,8 +22,14 @@ const (
 	campaignsLock = "campaign_event"
 
 	maxBatchSize = 100
+
+	// how many fires we check for having already been queued at once
+	checkBatchSize = 1000
 )
 
+// queuedFires is the group of event fires which have been queued to be fired
+var queuedFires = idempotency.NewGroup("campaign_event", time.Hour*24)
+
 func init() {
 	mailroom.AddInitFunction(StartCampaignCron)
 }
@@ -31,10 +37,10 @@ func init() {
 // StartCampaignCron starts our cron job of firing expired campaign events
 func StartCampaignCron(mr *mailroom.Mailroom) error {
 	cron.StartCron(mr.Quit, mr.RP, campaignsLock, time.Second*60,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
-			return fireCampaignEvents(ctx, mr.DB, mr.RP, lockName, lockValue)
+			return fireCampaignEvents(ctx, mr.DB, mr.RP, mr.Queue, lockName, lockValue)
 		},
 	)
 
@@ -42,7 +48,7 @@ func StartCampaignCron(mr *mailroom.Mailroom) error {
 }
 
 // fireCampaignEvents looks for all expired campaign event fires and queues them to be started
-func fireCampaignEvents(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName string, lockValue string) error {
+func fireCampaignEvents(ctx context.Context, db *sqlx.DB, rp *redis.Pool, queues queue.Backend, lockName string, lockValue string) error {
 	log := logrus.WithField("comp", "campaign_events").WithField("lock", lockValue)
 	start := time.Now()
 
@@ -74,17 +80,15 @@ func fireCampaignEvents(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockNa
 			task.FireIDs = fireIDs[:batchSize]
 			fireIDs = fireIDs[batchSize:]
 
-			err = queue.AddTask(rc, queue.BatchQueue, queue.FireCampaignEvent, int(task.OrgID), task, queue.DefaultPriority)
+			err = queues.AddTask(queue.BatchQueue, queue.FireCampaignEvent, int(task.OrgID), task, queue.DefaultPriority)
 			if err != nil {
 				return errors.Wrap(err, "error queuing task")
 			}
 
-			// mark each of these fires as queued
-			for _, id := range task.FireIDs {
-				err = marker.AddTask(rc, campaignsLock, fmt.Sprintf("%d", id))
-				if err != nil {
-					return errors.Wrap(err, "error marking event as queued")
-				}
+			// mark these fires as queued
+			err = queuedFires.Add(rc, fireKeys(task.FireIDs)...)
+			if err != nil {
+				return errors.Wrap(err, "error marking event as queued")
 			}
 			log.WithField("task", fmt.Sprintf("%vvv", task)).WithField("fire_count", len(task.FireIDs)).Debug("added event fire task")
 			queued += len(task.FireIDs)
@@ -93,51 +97,81 @@ func fireCampaignEvents(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockNa
 		return nil
 	}
 
-	// while we have rows
+	// adds the passed in rows which haven't already been queued to our tasks, queuing each when it is complete
 	task := &eventFireTask{}
-	for rows.Next() {
-		row := &eventFireRow{}
-		err := rows.StructScan(row)
-		if err != nil {
-			return errors.Wrapf(err, "error reading event fire row")
+	addRows := func(batch []*eventFireRow) error {
+		keys := make([]string, len(batch))
+		for i, row := range batch {
+			keys[i] = fmt.Sprintf("%d", row.FireID)
 		}
 
-		// check whether this event has already been queued to fire
-		taskID := fmt.Sprintf("%d", row.FireID)
-		dupe, err := marker.HasTask(rc, campaignsLock, taskID)
+		// check which of these fires have already been queued
+		unqueued, err := queuedFires.Filter(rc, keys)
 		if err != nil {
 			return errors.Wrap(err, "error checking task lock")
 		}
-
-		// this has already been queued, move on
-		if dupe {
-			continue
+		isUnqueued := make(map[string]bool, len(unqueued))
+		for _, key := range unqueued {
+			isUnqueued[key] = true
 		}
 
-		// if this is the same event as our current task, add it there
-		if row.EventID == task.EventID {
-			task.FireIDs = append(task.FireIDs, row.FireID)
-			continue
+		for i, row := range batch {
+			// this has already been queued, move on
+			if !isUnqueued[keys[i]] {
+				continue
+			}
+
+			// if this is the same event as our current task, add it there
+			if row.EventID == task.EventID {
+				task.FireIDs = append(task.FireIDs, row.FireID)
+				continue
+			}
+
+			// different task, queue up our current task
+			err = queueTask(task)
+			if err != nil {
+				return errors.Wrapf(err, "error queueing task")
+			}
+
+			// and create a new one based on this row
+			task = &eventFireTask{
+				FireIDs:      []int64{row.FireID},
+				EventID:      row.EventID,
+				EventUUID:    row.EventUUID,
+				FlowUUID:     row.FlowUUID,
+				CampaignUUID: row.CampaignUUID,
+				CampaignName: row.CampaignName,
+				OrgID:        row.OrgID,
+			}
 		}
+		return nil
+	}
 
-		// different task, queue up our current task
-		err = queueTask(task)
+	// while we have rows
+	batch := make([]*eventFireRow, 0, checkBatchSize)
+	for rows.Next() {
+		row := &eventFireRow{}
+		err := rows.StructScan(row)
 		if err != nil {
-			return errors.Wrapf(err, "error queueing task")
+			return errors.Wrapf(err, "error reading event fire row")
 		}
 
-		// and create a new one based on this row
-		task = &eventFireTask{
-			FireIDs:      []int64{row.FireID},
-			EventID:      row.EventID,
-			EventUUID:    row.EventUUID,
-			FlowUUID:     row.FlowUUID,
-			CampaignUUID: row.CampaignUUID,
-			CampaignName: row.CampaignName,
-			OrgID:        row.OrgID,
+		batch = append(batch, row)
+		if len(batch) == checkBatchSize {
+			err = addRows(batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
 		}
 	}
 
+	// add any stragglers
+	err = addRows(batch)
+	if err != nil {
+		return err
+	}
+
 	// queue our last task
 	err = queueTask(task)
 	if err != nil {
@@ -150,6 +184,15 @@ func fireCampaignEvents(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockNa
 	return nil
 }
 
+// fireKeys returns the keys we use to mark the passed in fires as queued
+func fireKeys(fireIDs []int64) []string {
+	keys := make([]string, len(fireIDs))
+	for i, id := range fireIDs {
+		keys[i] = fmt.Sprintf("%d", id)
+	}
+	return keys
+}
+
 type eventFireTask struct {
 	FireIDs      []int64         `json:"fire_ids"`
 	EventID      int64           `json:"event_id"`
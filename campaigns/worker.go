@@ -3,14 +3,12 @@ package campaigns
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/flows/triggers"
 	"github.com/nyaruka/mailroom"
-	"github.com/nyaruka/mailroom/marker"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/nyaruka/mailroom/queue"
 	"github.com/nyaruka/mailroom/runner"
@@ -19,7 +17,8 @@ import (
 )
 
 func init() {
-	mailroom.AddTaskFunction(queue.FireCampaignEvent, HandleCampaignEvent)
+	// this isn't retried, fires which fail are unmarked as queued so that our cron fires them again
+	mailroom.AddTaskFunction(queue.FireCampaignEvent, HandleCampaignEvent, nil)
 }
 
 // HandleCampaignEvent is called by mailroom when a campaign event task is ready to be processed.
@@ -55,13 +54,11 @@ func fireEventFires(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *queu
 	// grab all the fires for this event
 	fires, err := models.LoadEventFires(ctx, db, eventTask.FireIDs)
 	if err != nil {
-		// unmark all these fires as fires so they can retry
+		// unmark all these fires as queued so they can retry
 		rc := rp.Get()
-		for _, id := range eventTask.FireIDs {
-			rerr := marker.RemoveTask(rc, campaignsLock, fmt.Sprintf("%d", id))
-			if rerr != nil {
-				log.WithError(rerr).WithField("fire_id", id).Error("error unmarking campaign fire")
-			}
+		rerr := queuedFires.Remove(rc, fireKeys(eventTask.FireIDs)...)
+		if rerr != nil {
+			log.WithError(rerr).WithField("fire_ids", eventTask.FireIDs).Error("error unmarking campaign fires")
 		}
 		rc.Close()
 
@@ -98,10 +95,13 @@ func fireEventFires(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *queu
 
 	// what remains in our contact map are fires that failed for some reason, umark these
 	if len(contactMap) > 0 {
-		rc := rp.Get()
+		failedIDs := make([]int64, 0, len(contactMap))
 		for _, failed := range contactMap {
-			marker.RemoveTask(rc, campaignsLock, fmt.Sprintf("%d", failed.FireID))
+			failedIDs = append(failedIDs, int64(failed.FireID))
 		}
+
+		rc := rp.Get()
+		queuedFires.Remove(rc, fireKeys(failedIDs)...)
 		rc.Close()
 	}
 
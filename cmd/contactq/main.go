@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nyaruka/ezconf"
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/handler"
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Config is our configuration for inspecting and repairing a single contact's event queue
+type Config struct {
+	Redis     string `help:"URL describing how to connect to Redis"`
+	OrgID     int    `help:"the id of the org of the contact"`
+	ContactID int    `help:"the id of the contact"`
+	Action    string `help:"what to do, one of list, drop or unlock"`
+	Index     int    `help:"the index of the event to drop"`
+	Type      string `help:"the type of the event to drop, which must match the event at that index"`
+	Actor     string `help:"who to record in the audit log as taking this action"`
+}
+
+func main() {
+	config := &Config{
+		Redis:  "redis://localhost:6379/15",
+		Action: "list",
+		Actor:  fmt.Sprintf("cli:%s", os.Getenv("USER")),
+	}
+	loader := ezconf.NewLoader(
+		config,
+		"mailroom", "contactq - inspect and repair the event queue of a single contact",
+		nil,
+	)
+	loader.MustLoad()
+
+	logrus.SetOutput(os.Stderr)
+
+	if config.OrgID == 0 || config.ContactID == 0 {
+		logrus.Fatal("org id and contact id are required")
+	}
+	orgID, contactID := models.OrgID(config.OrgID), models.ContactID(config.ContactID)
+
+	rp, err := mailroom.NewRedisPool(config.Redis)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	var result interface{}
+
+	switch config.Action {
+	case "list":
+		rc := rp.Get()
+		events, err := handler.ContactEvents(rc, orgID, contactID, config.Actor)
+		rc.Close()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		locked, err := locker.IsLocked(rp, models.ContactLock(orgID, contactID))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		result = map[string]interface{}{"locked": locked, "events": events}
+
+	case "drop":
+		if config.Type == "" {
+			logrus.Fatal("type of the event to drop is required")
+		}
+		if config.Index < 0 {
+			logrus.Fatal("index of the event to drop can't be negative")
+		}
+
+		rc := rp.Get()
+		dropped, err := handler.DropContactEvent(rc, orgID, contactID, config.Index, config.Type, config.Actor)
+		rc.Close()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if !dropped {
+			logrus.Fatalf("no %s event at index %d", config.Type, config.Index)
+		}
+		result = map[string]interface{}{"dropped": true}
+
+	case "unlock":
+		released, err := handler.UnlockContact(rp, orgID, contactID, config.Actor)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		result = map[string]interface{}{"released": released}
+
+	default:
+		logrus.Fatalf("unknown action '%s', must be one of list, drop or unlock", config.Action)
+	}
+
+	// write our result to stdout as JSON, our logging goes to stderr
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		logrus.Fatal(err)
+	}
+}
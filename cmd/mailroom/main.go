@@ -15,6 +15,7 @@ import (
 
 	_ "github.com/nyaruka/mailroom/broadcasts"
 	_ "github.com/nyaruka/mailroom/campaigns"
+	_ "github.com/nyaruka/mailroom/contacts"
 	_ "github.com/nyaruka/mailroom/expirations"
 	_ "github.com/nyaruka/mailroom/hooks"
 	_ "github.com/nyaruka/mailroom/ivr"
@@ -22,10 +23,15 @@ import (
 	_ "github.com/nyaruka/mailroom/stats"
 	_ "github.com/nyaruka/mailroom/timeouts"
 
+	_ "github.com/nyaruka/mailroom/web/broadcast"
+	_ "github.com/nyaruka/mailroom/web/contact"
+	_ "github.com/nyaruka/mailroom/web/cron"
 	_ "github.com/nyaruka/mailroom/web/docs"
 	_ "github.com/nyaruka/mailroom/web/flow"
 	_ "github.com/nyaruka/mailroom/web/ivr"
+	_ "github.com/nyaruka/mailroom/web/queue"
 	_ "github.com/nyaruka/mailroom/web/simulation"
+	_ "github.com/nyaruka/mailroom/web/start"
 	_ "github.com/nyaruka/mailroom/web/surveyor"
 
 	_ "github.com/nyaruka/mailroom/ivr/nexmo"
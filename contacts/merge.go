@@ -0,0 +1,123 @@
+package contacts
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	mailroom.AddTaskFunction(queue.MergeContacts, handleMergeContacts, queue.DefaultRetryPolicy)
+}
+
+// MergeTask is our task for merging the loser contact into the survivor contact
+type MergeTask struct {
+	SurvivorID models.ContactID    `json:"survivor_id" validate:"required"`
+	LoserID    models.ContactID    `json:"loser_id"    validate:"required"`
+	Options    models.MergeOptions `json:"options"`
+}
+
+// QueueMerge queues a task to merge the loser contact into the survivor contact in the passed in org. Merges are queued
+// on request, and when a contact takes a URN from another contact, in which case the contact taking it survives.
+func QueueMerge(rc redis.Conn, orgID models.OrgID, task *MergeTask) error {
+	return queue.QueueTask(rc, queue.BatchQueue, queue.MergeContacts, int(orgID), task, queue.DefaultPriority)
+}
+
+// handleMergeContacts handles a task to merge two contacts
+func handleMergeContacts(ctx context.Context, mr *mailroom.Mailroom, task *queue.Task) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	mergeTask := &MergeTask{}
+	err := json.Unmarshal(task.Task, mergeTask)
+	if err != nil {
+		return errors.Wrapf(err, "error unmarshalling merge contacts task: %s", string(task.Task))
+	}
+
+	return Merge(ctx, mr.DB, mr.RP, models.OrgID(task.OrgID), mergeTask)
+}
+
+// Merge merges the loser contact into the survivor contact, holding the locks of both contacts so that no events are
+// handled for either while they are merged
+func Merge(ctx context.Context, db *sqlx.DB, rp *redis.Pool, orgID models.OrgID, task *MergeTask) error {
+	start := time.Now()
+
+	org, err := models.GetOrgAssets(ctx, db, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading org")
+	}
+
+	// always grab the locks in the same order so concurrent merges can't deadlock
+	contactIDs := []models.ContactID{task.SurvivorID, task.LoserID}
+	sort.Slice(contactIDs, func(i, j int) bool { return contactIDs[i] < contactIDs[j] })
+
+	// our locks are renewed for as long as we are merging, each lock's context is derived from the one before, so if
+	// we lose either lock our context is cancelled and we stop
+	for _, contactID := range contactIDs {
+		lock, err := locker.GrabRenewingLock(ctx, rp, models.ContactLock(orgID, contactID), time.Minute*5, time.Minute)
+		if err != nil {
+			return errors.Wrapf(err, "error grabbing lock for contact: %d", contactID)
+		}
+		if lock == nil {
+			return errors.Errorf("timed out waiting for lock for contact: %d", contactID)
+		}
+		defer lock.Release()
+
+		ctx = lock.Context()
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error starting transaction for merge")
+	}
+
+	err = models.MergeContacts(ctx, tx, org, task.SurvivorID, task.LoserID, &task.Options)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "error committing merge")
+	}
+
+	// the survivor may now belong in different dynamic groups
+	contacts, err := models.LoadContacts(ctx, db, org, []models.ContactID{task.SurvivorID})
+	if err != nil {
+		return errors.Wrapf(err, "error loading merged contact")
+	}
+	if len(contacts) == 0 {
+		return errors.Errorf("unable to find merged contact: %d", task.SurvivorID)
+	}
+
+	sa, err := models.GetSessionAssets(org)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load session assets")
+	}
+
+	contact, err := contacts[0].FlowContact(org, sa)
+	if err != nil {
+		return errors.Wrapf(err, "error creating flow contact")
+	}
+
+	err = models.CalculateDynamicGroups(ctx, db, org, contact)
+	if err != nil {
+		return errors.Wrapf(err, "error calculating dynamic groups of merged contact")
+	}
+
+	logrus.WithField("org_id", orgID).WithField("survivor_id", task.SurvivorID).WithField("loser_id", task.LoserID).
+		WithField("elapsed", time.Since(start)).Info("merged contacts")
+
+	return nil
+}
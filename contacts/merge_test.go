@@ -0,0 +1,33 @@
+package contacts
+
+import (
+	"testing"
+
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	rp := testsuite.RP()
+	ctx := testsuite.CTX()
+
+	err := Merge(ctx, db, rp, models.Org1, &MergeTask{SurvivorID: models.CathyID, LoserID: models.GeorgeID})
+	assert.NoError(t, err)
+
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contacturn WHERE contact_id = $1`, []interface{}{models.CathyID}, 2)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND is_active = FALSE`, []interface{}{models.GeorgeID}, 1)
+
+	// both contacts are unlocked afterwards
+	locked, err := locker.IsLocked(rp, models.ContactLock(models.Org1, models.CathyID))
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	// merging an already merged contact fails
+	err = Merge(ctx, db, rp, models.Org1, &MergeTask{SurvivorID: models.CathyID, LoserID: models.GeorgeID})
+	assert.Error(t, err)
+
+}
@@ -1,33 +1,119 @@
 package cron
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/apex/log"
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/librato"
 	"github.com/nyaruka/mailroom/locker"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Function is the function that will be called on our schedule
-type Function func(lockName string, lockValue string) error
+// Function is the function that will be called on our schedule, the passed in context is cancelled if
+// our lock is lost while the function is running
+type Function func(ctx context.Context, lockName string, lockValue string) error
 
-// StartCron calls the passed in function every minute, making sure it acquires a
-// lock so that only one process is running at once. Note that across processes
-// crons may be called more often than duration as there is no inter-process
-// coordination of cron fires. (this might be a worthy addition)
+const (
+	// statusKey is the redis hash where we keep the state of each cron's last run
+	statusKey = "cron:%s"
+
+	// OutcomeSuccess is the outcome of a cron run which completed without error
+	OutcomeSuccess = "success"
+
+	// OutcomeError is the outcome of a cron run which returned an error
+	OutcomeError = "error"
+
+	// OutcomePanic is the outcome of a cron run which panicked
+	OutcomePanic = "panic"
+)
+
+// Status is the state of the last run of a cron, shared by all processes
+type Status struct {
+	Name         string     `json:"name"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastFinish   *time.Time `json:"last_finish,omitempty"`
+	LastDuration float64    `json:"last_duration,omitempty"`
+	LastOutcome  string     `json:"last_outcome,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// GetStatus returns the state of the last run of the cron with the passed in name
+func GetStatus(rc redis.Conn, name string) (*Status, error) {
+	values, err := redis.StringMap(rc.Do("hgetall", fmt.Sprintf(statusKey, name)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting status for cron: %s", name)
+	}
+
+	status := &Status{
+		Name:        name,
+		LastOutcome: values["last_outcome"],
+		LastError:   values["last_error"],
+	}
+	if values["last_start"] != "" {
+		lastStart, err := time.Parse(time.RFC3339Nano, values["last_start"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid last start for cron: %s", name)
+		}
+		status.LastStart = &lastStart
+	}
+	if values["last_finish"] != "" {
+		lastFinish, err := time.Parse(time.RFC3339Nano, values["last_finish"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid last finish for cron: %s", name)
+		}
+		status.LastFinish = &lastFinish
+	}
+	if values["last_duration"] != "" {
+		status.LastDuration, err = strconv.ParseFloat(values["last_duration"], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid last duration for cron: %s", name)
+		}
+	}
+
+	return status, nil
+}
+
+// recordStart records that the cron with the passed in name has started
+func recordStart(rc redis.Conn, name string, start time.Time) error {
+	_, err := rc.Do("hset", fmt.Sprintf(statusKey, name), "last_start", start.Format(time.RFC3339Nano))
+	return err
+}
+
+// recordFinish records that the cron with the passed in name has finished with the passed in outcome
+func recordFinish(rc redis.Conn, name string, start time.Time, outcome string, cronErr error) error {
+	finish := time.Now()
+	errMsg := ""
+	if cronErr != nil {
+		errMsg = cronErr.Error()
+	}
+
+	_, err := rc.Do("hmset", fmt.Sprintf(statusKey, name),
+		"last_finish", finish.Format(time.RFC3339Nano),
+		"last_duration", strconv.FormatFloat(float64(finish.Sub(start))/float64(time.Second), 'f', 6, 64),
+		"last_outcome", outcome,
+		"last_error", errMsg,
+	)
+	return err
+}
+
+// StartCron calls the passed in function every interval, making sure it acquires a lock so that only one
+// process is running at once. The start of each run is recorded in redis and the next fire is calculated
+// from that, so across processes the function is still only called once per interval.
 func StartCron(quit chan bool, rp *redis.Pool, name string, interval time.Duration, cronFunc Function) {
 	lockName := fmt.Sprintf("%s_lock", name)
 	wait := time.Duration(0)
-	lastFire := time.Now()
 
 	log := logrus.WithField("cron", name).WithField("lockName", lockName)
 
+	register(name, interval)
+
 	go func() {
 		defer log.Info("exiting")
 
-		// we run expiration every minute on the minute
 		for true {
 			select {
 			case <-quit:
@@ -35,56 +121,132 @@ func StartCron(quit chan bool, rp *redis.Pool, name string, interval time.Durati
 				return
 
 			case <-time.After(wait):
-				// try to insert our expiring lock to redis
-				lastFire = time.Now()
+				next := runIfDue(rp, name, lockName, interval, cronFunc)
 
-				lock, err := locker.GrabLock(rp, lockName, time.Minute*5, 0)
-				if err != nil {
-					break
+				// calculate how long until we should next check
+				wait = next.Sub(time.Now())
+				if wait < time.Duration(0) {
+					wait = time.Duration(0)
 				}
-				log := log.WithField("lock", lock)
+			}
+		}
+	}()
+}
 
-				if lock == "" {
-					log.Debug("lock already present, sleeping")
-					break
-				}
+// runIfDue runs our cron function if it hasn't been run by any process within its interval, returning the
+// time we should next check whether it is due
+func runIfDue(rp *redis.Pool, name string, lockName string, interval time.Duration, cronFunc Function) time.Time {
+	log := logrus.WithField("cron", name).WithField("lockName", lockName)
+	now := time.Now()
 
-				// ok, got the lock, run our cron function
-				err = fireCron(cronFunc, lockName, lock)
-				if err != nil {
-					log.WithError(err).Error("error while running cron")
-				}
+	// not due yet? check again when we are
+	next, err := nextClusterFire(rp, name, interval, now)
+	if err != nil {
+		log.WithError(err).Error("error getting cron status")
+		return nextFire(now, interval)
+	}
+	if next.After(now) {
+		return next
+	}
 
-				// release our lock
-				err = locker.ReleaseLock(rp, lockName, lock)
-				if err != nil {
-					log.WithError(err).Error("error releasing lock")
-				}
-			}
+	// try to insert our expiring lock to redis, it is renewed for as long as we are running
+	lock, err := locker.GrabRenewingLock(context.Background(), rp, lockName, time.Minute*5, 0)
+	if err != nil {
+		log.WithError(err).Error("error grabbing cron lock")
+		return nextFire(now, interval)
+	}
 
-			// calculate our next fire time
-			nextFire := nextFire(lastFire, interval)
-			wait = nextFire.Sub(time.Now())
-			if wait < time.Duration(0) {
-				wait = time.Duration(0)
-			}
+	if lock == nil {
+		log.Debug("lock already present, sleeping")
+		recordSkipped(name)
+		return nextFire(now, interval)
+	}
+	log = log.WithField("lock", lock.Value())
+
+	// release our lock when we're done
+	defer func() {
+		err := lock.Release()
+		if err != nil {
+			log.WithError(err).Error("error releasing lock")
 		}
 	}()
+
+	// now that we have the lock, check again that another process didn't just run
+	next, err = nextClusterFire(rp, name, interval, now)
+	if err != nil {
+		log.WithError(err).Error("error getting cron status")
+		return nextFire(now, interval)
+	}
+	if next.After(now) {
+		return next
+	}
+
+	rc := rp.Get()
+	err = recordStart(rc, name, now)
+	rc.Close()
+	if err != nil {
+		log.WithError(err).Error("error recording cron start")
+	}
+
+	// ok, got the lock, run our cron function
+	outcome, err := fireCron(lock.Context(), cronFunc, lockName, lock.Value())
+	if err != nil {
+		log.WithError(err).Error("error while running cron")
+	}
+
+	recordFired(name, now, err)
+	librato.Gauge(fmt.Sprintf("mr.cron_%s_elapsed", name), float64(time.Since(now))/float64(time.Second))
+	if err != nil {
+		librato.Gauge(fmt.Sprintf("mr.cron_%s_errors", name), 1)
+	} else {
+		librato.Gauge(fmt.Sprintf("mr.cron_%s_errors", name), 0)
+	}
+
+	rc = rp.Get()
+	err = recordFinish(rc, name, now, outcome, err)
+	rc.Close()
+	if err != nil {
+		log.WithError(err).Error("error recording cron finish")
+	}
+
+	return nextFire(now, interval)
+}
+
+// nextClusterFire returns when the cron with the passed in name is next due based on when it was last started
+// by any process, returning now if it has never been started
+func nextClusterFire(rp *redis.Pool, name string, interval time.Duration, now time.Time) (time.Time, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	status, err := GetStatus(rc, name)
+	if err != nil {
+		return now, err
+	}
+	if status.LastStart == nil {
+		return now, nil
+	}
+	return nextFire(*status.LastStart, interval), nil
 }
 
 // fireCron is just a wrapper around the cron function we will call for the purposes of
-// catching and logging panics
-func fireCron(cronFunc Function, lockName string, lockValue string) error {
-	log := log.WithField("lockValue", lockValue).WithField("func", cronFunc)
+// catching and logging panics, it returns the outcome of the run and any error
+func fireCron(ctx context.Context, cronFunc Function, lockName string, lockValue string) (outcome string, err error) {
+	log := logrus.WithField("lockValue", lockValue).WithField("func", cronFunc)
 	defer func() {
 		// catch any panics and recover
 		panicLog := recover()
 		if panicLog != nil {
 			log.Errorf("panic running cron: %s", panicLog)
+			outcome = OutcomePanic
+			err = errors.Errorf("panic running cron: %s", panicLog)
 		}
 	}()
 
-	return cronFunc(lockName, lockValue)
+	err = cronFunc(ctx, lockName, lockValue)
+	if err != nil {
+		return OutcomeError, err
+	}
+	return OutcomeSuccess, nil
 }
 
 // nextFire returns the next time we should fire based on the passed in time and interval
@@ -1,6 +1,8 @@
 package cron
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -20,7 +22,7 @@ func TestCron(t *testing.T) {
 	quit := make(chan bool)
 
 	// our cron worker is just going to increment an int on every fire
-	increment := func(lockName string, lockValue string) error {
+	increment := func(ctx context.Context, lockName string, lockValue string) error {
 		mutex.Lock()
 		fired++
 		mutex.Unlock()
@@ -34,6 +36,110 @@ func TestCron(t *testing.T) {
 	assert.Equal(t, 4, fired)
 
 	close(quit)
+
+	// start the same cron twice, as if in two processes, it still only fires once per interval
+	testsuite.ResetRP()
+	fired = 0
+	quit = make(chan bool)
+
+	StartCron(quit, rp, "test", time.Millisecond*100, increment)
+	StartCron(quit, rp, "test", time.Millisecond*100, increment)
+
+	time.Sleep(time.Millisecond * 320)
+	mutex.RLock()
+	assert.Equal(t, 4, fired)
+	mutex.RUnlock()
+
+	close(quit)
+
+	status, err := GetStatus(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, status.LastStart)
+	assert.NotNil(t, status.LastFinish)
+	assert.Equal(t, OutcomeSuccess, status.LastOutcome)
+	assert.Equal(t, "", status.LastError)
+}
+
+func TestCronStatus(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	status, err := GetStatus(rc, "failing")
+	assert.NoError(t, err)
+	assert.Nil(t, status.LastStart)
+	assert.Equal(t, "", status.LastOutcome)
+
+	quit := make(chan bool)
+	StartCron(quit, rp, "failing", time.Minute, func(ctx context.Context, lockName string, lockValue string) error {
+		return errors.New("boom")
+	})
+	StartCron(quit, rp, "panicking", time.Minute, func(ctx context.Context, lockName string, lockValue string) error {
+		panic("kaboom")
+	})
+
+	time.Sleep(time.Millisecond * 100)
+	close(quit)
+
+	status, err = GetStatus(rc, "failing")
+	assert.NoError(t, err)
+	assert.NotNil(t, status.LastStart)
+	assert.Equal(t, OutcomeError, status.LastOutcome)
+	assert.Equal(t, "boom", status.LastError)
+
+	status, err = GetStatus(rc, "panicking")
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomePanic, status.LastOutcome)
+	assert.Equal(t, "panic running cron: kaboom", status.LastError)
+}
+
+func TestRegistry(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	quit := make(chan bool)
+	StartCron(quit, rp, "registered", time.Minute, func(ctx context.Context, lockName string, lockValue string) error {
+		return errors.New("boom")
+	})
+
+	time.Sleep(time.Millisecond * 100)
+
+	// start another instance while the first isn't due, it shouldn't fire or be registered twice
+	StartCron(quit, rp, "registered", time.Minute, func(ctx context.Context, lockName string, lockValue string) error {
+		return nil
+	})
+
+	time.Sleep(time.Millisecond * 100)
+	close(quit)
+
+	var registration *Registration
+	for _, r := range Registered() {
+		if r.Name == "registered" {
+			registration = r
+		}
+	}
+	if assert.NotNil(t, registration) {
+		assert.Equal(t, float64(60), registration.Interval)
+		assert.NotNil(t, registration.LastFire)
+		assert.Equal(t, "boom", registration.LastError)
+		assert.False(t, registration.LockSkipped)
+	}
+
+	// our lock is held elsewhere, so we skip firing
+	recordSkipped("registered")
+
+	infos, err := GetInfos(rc)
+	assert.NoError(t, err)
+	for _, info := range infos {
+		if info.Name == "registered" {
+			assert.True(t, info.LockSkipped)
+			assert.Equal(t, OutcomeError, info.LastRun.LastOutcome)
+			assert.False(t, info.Overdue)
+		}
+	}
 }
 
 func TestNextFire(t *testing.T) {
@@ -0,0 +1,113 @@
+package cron
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Registration is a cron started in this process, along with what happened the last time this process tried to fire it
+type Registration struct {
+	Name         string     `json:"name"`
+	Interval     float64    `json:"interval"`
+	StartedOn    time.Time  `json:"started_on"`
+	LastFire     *time.Time `json:"last_fire,omitempty"`
+	LastDuration float64    `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LockSkipped  bool       `json:"lock_skipped"`
+}
+
+// Info is the state of a cron registered in this process, and of its last run by any process
+type Info struct {
+	*Registration
+	LastRun *Status `json:"last_run"`
+	Overdue bool    `json:"overdue"`
+}
+
+var registry = make(map[string]*Registration)
+var registryMutex sync.RWMutex
+
+// register adds the cron with the passed in name to our registry
+func register(name string, interval time.Duration) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if registry[name] == nil {
+		registry[name] = &Registration{Name: name, Interval: float64(interval) / float64(time.Second), StartedOn: time.Now()}
+	}
+}
+
+// recordSkipped records that this process skipped firing the passed in cron because another held its lock
+func recordSkipped(name string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if registry[name] != nil {
+		registry[name].LockSkipped = true
+	}
+}
+
+// recordFired records that this process fired the passed in cron
+func recordFired(name string, start time.Time, err error) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	r := registry[name]
+	if r == nil {
+		return
+	}
+
+	r.LastFire = &start
+	r.LastDuration = float64(time.Since(start)) / float64(time.Second)
+	r.LockSkipped = false
+	r.LastError = ""
+	if err != nil {
+		r.LastError = err.Error()
+	}
+}
+
+// Registered returns the crons registered in this process, sorted by name
+func Registered() []*Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	registered := make([]*Registration, 0, len(registry))
+	for _, r := range registry {
+		copy := *r
+		registered = append(registered, &copy)
+	}
+
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Name < registered[j].Name })
+	return registered
+}
+
+// GetInfos returns the state of each cron registered in this process and of its last run by any process. A cron
+// is overdue if it has missed a whole interval since it was next due.
+func GetInfos(rc redis.Conn) ([]*Info, error) {
+	now := time.Now()
+	registered := Registered()
+	infos := make([]*Info, len(registered))
+
+	for i, r := range registered {
+		status, err := GetStatus(rc, r.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		interval := time.Duration(r.Interval * float64(time.Second))
+		lastStart := r.StartedOn
+		if status.LastStart != nil {
+			lastStart = *status.LastStart
+		}
+
+		infos[i] = &Info{
+			Registration: r,
+			LastRun:      status,
+			Overdue:      now.After(nextFire(lastStart, interval).Add(interval)),
+		}
+	}
+
+	return infos, nil
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/cron"
 	"github.com/nyaruka/mailroom/handler"
-	"github.com/nyaruka/mailroom/marker"
+	"github.com/nyaruka/mailroom/idempotency"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -19,10 +19,12 @@ import (
 
 const (
 	expirationLock  = "run_expirations"
-	markerGroup     = "run_expirations"
 	expireBatchSize = 500
 )
 
+// queuedExpirations is the group of expirations which have been queued to be handled
+var queuedExpirations = idempotency.NewGroup("run_expirations", time.Hour*24)
+
 func init() {
 	mailroom.AddInitFunction(StartExpirationCron)
 }
@@ -30,8 +32,8 @@ func init() {
 // StartExpirationCron starts our cron job of expiring runs every minute
 func StartExpirationCron(mr *mailroom.Mailroom) error {
 	cron.StartCron(mr.Quit, mr.RP, expirationLock, time.Second*60,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 			return expireRuns(ctx, mr.DB, mr.RP, lockName, lockValue)
 		},
@@ -51,6 +53,9 @@ func expireRuns(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName strin
 	expiredRuns := make([]models.FlowRunID, 0, expireBatchSize)
 	expiredSessions := make([]models.SessionID, 0, expireBatchSize)
 
+	// and queue those that do to be handled in batches
+	continued := make([]*RunExpiration, 0, expireBatchSize)
+
 	// select our expired runs
 	rows, err := db.QueryxContext(ctx, selectExpiredRunsSQL)
 	if err != nil {
@@ -87,29 +92,20 @@ func expireRuns(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName strin
 		}
 
 		// need to continue this session and flow, create a task for that
-		taskID := fmt.Sprintf("%d:%s", expiration.RunID, expiration.ExpiresOn.Format(time.RFC3339))
-		queued, err := marker.HasTask(rc, markerGroup, taskID)
-		if err != nil {
-			return errors.Wrapf(err, "error checking whether expiration is queued")
-		}
-
-		// already queued? move on
-		if queued {
-			continue
-		}
-
-		// ok, queue this task
-		task := handler.NewExpirationTask(expiration.OrgID, expiration.ContactID, expiration.SessionID, expiration.RunID, expiration.ExpiresOn)
-		err = handler.AddHandleTask(rc, expiration.ContactID, task)
-		if err != nil {
-			return errors.Wrapf(err, "error adding new expiration task")
+		continued = append(continued, expiration)
+		if len(continued) == expireBatchSize {
+			err = queueExpirations(rc, continued)
+			if err != nil {
+				return err
+			}
+			continued = continued[:0]
 		}
+	}
 
-		// and mark it as queued
-		err = marker.AddTask(rc, markerGroup, taskID)
-		if err != nil {
-			return errors.Wrapf(err, "error marking expiration task as queued")
-		}
+	// queue any stragglers
+	err = queueExpirations(rc, continued)
+	if err != nil {
+		return err
 	}
 
 	// commit any stragglers
@@ -124,6 +120,35 @@ func expireRuns(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName strin
 	return nil
 }
 
+// queueExpirations queues handle tasks for those of the passed in expirations which haven't already been queued
+func queueExpirations(rc redis.Conn, expirations []*RunExpiration) error {
+	expirationsByTask := make(map[string]*RunExpiration, len(expirations))
+	taskIDs := make([]string, len(expirations))
+	for i, expiration := range expirations {
+		taskIDs[i] = fmt.Sprintf("%d:%s", expiration.RunID, expiration.ExpiresOn.Format(time.RFC3339))
+		expirationsByTask[taskIDs[i]] = expiration
+	}
+
+	// mark those which haven't already been queued as queued
+	unqueued, err := queuedExpirations.CheckAndAdd(rc, taskIDs)
+	if err != nil {
+		return errors.Wrapf(err, "error marking expiration tasks as queued")
+	}
+
+	for i, taskID := range unqueued {
+		expiration := expirationsByTask[taskID]
+		task := handler.NewExpirationTask(expiration.OrgID, expiration.ContactID, expiration.SessionID, expiration.RunID, expiration.ExpiresOn)
+		err = handler.AddHandleTask(rc, expiration.ContactID, task)
+		if err != nil {
+			// unmark those we didn't get to so that they are queued next time
+			queuedExpirations.Remove(rc, unqueued[i:]...)
+			return errors.Wrapf(err, "error adding new expiration task")
+		}
+	}
+
+	return nil
+}
+
 const selectExpiredRunsSQL = `
 	SELECT
 		fr.org_id as org_id,
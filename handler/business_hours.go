@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/triggers"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/librato"
+	"github.com/nyaruka/mailroom/courier"
+	"github.com/nyaruka/mailroom/idempotency"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/runner"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// handleClosedMsg handles a message which nothing else handled, if it arrived outside of the org's business hours,
+// by starting the org's designated flow or sending its auto reply. We only do this once for each contact within the
+// org's reply window, so returns whether the message was handled.
+func handleClosedMsg(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, sa flows.SessionAssets, modelContact *models.Contact, contact *flows.Contact, channel *models.Channel, msgIn *flows.MsgIn, event *MsgEvent, topup models.TopupID, hook models.SessionCommitHook) (bool, error) {
+	log := logrus.WithField("org_id", org.OrgID()).WithField("contact_uuid", contact.UUID())
+
+	// misconfigured business hours shouldn't stop us handling the message
+	hours, err := models.LoadBusinessHours(org)
+	if err != nil {
+		log.WithError(err).Error("error loading business hours")
+		return false, nil
+	}
+	if hours == nil || (hours.Reply == "" && hours.FlowUUID == "") || hours.IsOpen(utils.Now(), org.Env().Timezone()) {
+		return false, nil
+	}
+
+	// and neither should a designated flow which has been deleted or can't be started by a message
+	var flow *models.Flow
+	if hours.FlowUUID != "" {
+		flow, err = loadClosedFlow(org, hours)
+		if err == models.ErrNotFound {
+			log.WithField("flow_uuid", hours.FlowUUID).Error("business hours flow not found or not a messaging flow")
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// check whether we've already responded to this contact within the window
+	window := hours.ReplyWindowDuration()
+	replied := idempotency.NewGroup(fmt.Sprintf("closed_replies_%d", int(window.Seconds())), window)
+	key := fmt.Sprintf("%d:%d", org.OrgID(), contact.ID())
+
+	rc := rp.Get()
+	added, err := replied.CheckAndAdd(rc, []string{key})
+	rc.Close()
+	if err != nil {
+		return false, err
+	}
+	if len(added) == 0 {
+		return false, nil
+	}
+
+	if flow != nil {
+		err = startClosedFlow(ctx, db, rp, org, sa, flow, contact, msgIn, hook)
+	} else {
+		err = sendClosedReply(ctx, db, rp, org, modelContact, channel, event, hours, topup)
+	}
+
+	// if we failed to respond, forget that we did so we can try again
+	if err != nil {
+		rc := rp.Get()
+		if rerr := replied.Remove(rc, key); rerr != nil {
+			log.WithError(rerr).Error("error forgetting business hours reply")
+		}
+		rc.Close()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// loadClosedFlow loads the flow designated in the passed in business hours, returning ErrNotFound if it doesn't exist
+// or isn't a messaging flow
+func loadClosedFlow(org *models.OrgAssets, hours *models.BusinessHours) (*models.Flow, error) {
+	asset, err := org.Flow(hours.FlowUUID)
+	if err == models.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading business hours flow: %s", hours.FlowUUID)
+	}
+
+	flow := asset.(*models.Flow)
+	if flow.FlowType() != models.MessagingFlow {
+		return nil, models.ErrNotFound
+	}
+	return flow, nil
+}
+
+// startClosedFlow starts the passed in business hours flow for the passed in contact
+func startClosedFlow(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, sa flows.SessionAssets, flow *models.Flow, contact *flows.Contact, msgIn *flows.MsgIn, hook models.SessionCommitHook) error {
+	trigger := triggers.NewMsgTrigger(org.Env(), flow.FlowReference(), contact, msgIn, nil)
+	_, err := runner.StartFlowForContacts(ctx, db, rp, org, sa, flow, []flows.Trigger{trigger}, hook, true)
+	if err != nil {
+		return errors.Wrapf(err, "error starting business hours flow for contact")
+	}
+
+	librato.Gauge("mr.business_hours_flow", 1)
+	return nil
+}
+
+// sendClosedReply sends the auto reply in the passed in business hours to the passed in contact, putting the message
+// they sent us in the inbox
+func sendClosedReply(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, contact *models.Contact, channel *models.Channel, event *MsgEvent, hours *models.BusinessHours, topup models.TopupID) error {
+	reply, err := buildReply(ctx, db, rp, org, contact, channel, event.URNID, hours.Reply)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to start transaction for business hours reply")
+	}
+
+	err = models.UpdateMessage(ctx, tx, event.MsgID, models.MsgStatusHandled, models.VisibilityVisible, models.TypeInbox, topup)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error marking message as handled")
+	}
+
+	if reply != nil {
+		err = models.InsertMessages(ctx, tx, []*models.Msg{reply})
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error inserting business hours reply")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit business hours reply")
+	}
+
+	if reply != nil {
+		rc := rp.Get()
+		defer rc.Close()
+
+		err = courier.QueueMessages(rc, []*models.Msg{reply})
+		if err != nil {
+			return errors.Wrapf(err, "error queuing business hours reply")
+		}
+	}
+
+	librato.Gauge("mr.business_hours_reply", 1)
+	return nil
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessHoursReplies(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	rp := testsuite.RP()
+	ctx := testsuite.CTX()
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	// business hours which are never open
+	db.MustExec(`UPDATE orgs_org SET config = '{"business_hours": {"schedule": [{"start": "09:00", "end": "09:00"}], "reply": "We are closed"}}' WHERE id = $1`, models.Org1)
+	models.FlushCache()
+
+	tcs := []struct {
+		ContactID models.ContactID
+		URN       urns.URN
+		URNID     models.URNID
+		Message   string
+		Response  string
+	}{
+		{models.CathyID, models.CathyURN, models.CathyURNID, "hello", "We are closed"},
+		{models.CathyID, models.CathyURN, models.CathyURNID, "hello again", "hello again"},
+		{models.GeorgeID, models.GeorgeURN, models.GeorgeURNID, "hi", "We are closed"},
+	}
+
+	for i, tc := range tcs {
+		msgID, msgUUID := insertTestMsg(t, tc.ContactID, tc.URNID, tc.Message)
+		event := &MsgEvent{
+			ContactID: tc.ContactID,
+			OrgID:     models.Org1,
+			ChannelID: models.TwitterChannelID,
+			MsgID:     msgID,
+			MsgUUID:   msgUUID,
+			URN:       tc.URN,
+			URNID:     tc.URNID,
+			Text:      tc.Message,
+		}
+		eventJSON, err := json.Marshal(event)
+		assert.NoError(t, err)
+
+		task := &queue.Task{Type: MsgEventType, OrgID: int(models.Org1), Task: eventJSON}
+		err = AddHandleTask(rc, tc.ContactID, task)
+		assert.NoError(t, err, "%d: error adding task", i)
+
+		task, err = queue.PopNextTask(rc, queue.HandlerQueue)
+		assert.NoError(t, err, "%d: error popping next task", i)
+
+		err = handleContactEvent(ctx, db, rp, task)
+		assert.NoError(t, err, "%d: error when handling event", i)
+
+		var text string
+		db.Get(&text, `SELECT text FROM msgs_msg WHERE contact_id = $1 ORDER BY id DESC LIMIT 1`, tc.ContactID)
+		assert.Equal(t, tc.Response, text, "%d: unexpected last message", i)
+	}
+
+	// a business hours flow which can't be started by a message is ignored and the message goes to the inbox
+	db.MustExec(`UPDATE orgs_org SET config = '{"business_hours": {"schedule": [{"start": "09:00", "end": "09:00"}], "flow_uuid": "`+string(models.IVRFlowUUID)+`"}}' WHERE id = $1`, models.Org1)
+	models.FlushCache()
+
+	msgID, msgUUID := insertTestMsg(t, models.BobID, models.BobURNID, "anyone there?")
+	err := handleMsgEvent(ctx, db, rp, &MsgEvent{
+		ContactID: models.BobID,
+		OrgID:     models.Org1,
+		ChannelID: models.TwitterChannelID,
+		MsgID:     msgID,
+		MsgUUID:   msgUUID,
+		URN:       models.BobURN,
+		URNID:     models.BobURNID,
+		Text:      "anyone there?",
+	}, 0)
+	assert.NoError(t, err)
+
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_msg WHERE id = $1 AND status = 'H' AND msg_type = 'I'`, []interface{}{msgID}, 1)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/librato"
+	"github.com/nyaruka/mailroom/courier"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// handleConsentKeyword handles an incoming message which is one of the org's opt out keywords, or opt in keywords from
+// a stopped contact. The contact is stopped or unstopped, any sessions they are in are interrupted, the change in
+// consent is recorded in the message's metadata, opt outs also as a stop channel event like those from channels, and
+// the org's confirmation message, if it has one, is sent to them.
+func handleConsentKeyword(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, modelContact *models.Contact, contact *flows.Contact, channel *models.Channel, event *MsgEvent, action models.ConsentAction, keyword string, topup models.TopupID) error {
+	now := time.Now()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to start transaction for consent change")
+	}
+
+	if action == models.OptOutAction {
+		err = models.StopContact(ctx, tx, org.OrgID(), modelContact.ID())
+	} else {
+		err = models.UnstopContact(ctx, tx, modelContact.ID())
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error changing consent for contact")
+	}
+
+	contactIDs := []flows.ContactID{contact.ID()}
+	for _, sessionType := range []models.FlowType{models.MessagingFlow, models.IVRFlow} {
+		err = models.InterruptContactRuns(ctx, tx, sessionType, contactIDs, now)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error interrupting sessions for consent change")
+		}
+	}
+
+	// record the change in consent on the message which asked for it
+	err = models.SetMsgMetadata(ctx, tx, event.MsgID, "consent", map[string]string{"action": string(action), "keyword": keyword})
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error recording consent change")
+	}
+
+	// and opt outs as a stop event, which is how channels tell us about them too
+	if action == models.OptOutAction {
+		stop := models.NewChannelEvent(models.StopContactEventType, org.OrgID(), event.ChannelID, modelContact.ID(), event.URNID,
+			map[string]interface{}{"keyword": keyword, "msg_id": event.MsgID}, false)
+		err = stop.Insert(ctx, tx)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error recording opt out")
+		}
+	}
+
+	err = models.UpdateMessage(ctx, tx, event.MsgID, models.MsgStatusHandled, models.VisibilityVisible, models.TypeInbox, topup)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error marking consent keyword message as handled")
+	}
+
+	confirmation, err := buildReply(ctx, db, rp, org, modelContact, channel, event.URNID, org.Org().ConfigValue(action.ConfirmationConfig(), ""))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if confirmation != nil {
+		err = models.InsertMessages(ctx, tx, []*models.Msg{confirmation})
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error inserting consent confirmation")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit consent change")
+	}
+
+	librato.Gauge("mr.consent_"+string(action), 1)
+	logrus.WithField("contact_uuid", contact.UUID()).WithField("action", action).WithField("keyword", keyword).Info("contact changed consent by keyword")
+
+	// contacts who opt in are back in their dynamic groups
+	if action == models.OptInAction {
+		err = models.CalculateDynamicGroups(ctx, db, org, contact)
+		if err != nil {
+			return errors.Wrapf(err, "unable to calculate groups for opted in contact")
+		}
+	}
+
+	if confirmation != nil {
+		rc := rp.Get()
+		defer rc.Close()
+
+		err = courier.QueueMessages(rc, []*models.Msg{confirmation})
+		if err != nil {
+			return errors.Wrapf(err, "error queuing consent confirmation")
+		}
+	}
+
+	return nil
+}
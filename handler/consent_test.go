@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsentKeywords(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	rp := testsuite.RP()
+	ctx := testsuite.CTX()
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"opt_out_keywords": "stop, arrêter", "opt_in_keywords": "start again", "opt_out_message": "You will no longer receive messages"}' WHERE id = $1`, models.Org1)
+	models.FlushCache()
+
+	tcs := []struct {
+		Message   string
+		Stopped   bool
+		OptOuts   int
+		OptIns    int
+		Response  string
+		Direction string
+	}{
+		{"hello", false, 0, 0, "hello", "I"},
+		{"start again", false, 0, 0, "start again", "I"},
+		{"Arreter!", true, 1, 0, "You will no longer receive messages", "O"},
+		{"start again", false, 1, 1, "start again", "I"},
+		{"STOP", true, 2, 1, "You will no longer receive messages", "O"},
+	}
+
+	for i, tc := range tcs {
+		msgID, msgUUID := insertTestMsg(t, models.CathyID, models.CathyURNID, tc.Message)
+
+		event := &MsgEvent{
+			ContactID: models.CathyID,
+			OrgID:     models.Org1,
+			ChannelID: models.TwitterChannelID,
+			MsgID:     msgID,
+			MsgUUID:   msgUUID,
+			URN:       models.CathyURN,
+			URNID:     models.CathyURNID,
+			Text:      tc.Message,
+		}
+		eventJSON, err := json.Marshal(event)
+		assert.NoError(t, err)
+
+		task := &queue.Task{Type: MsgEventType, OrgID: int(models.Org1), Task: eventJSON}
+		err = AddHandleTask(rc, models.CathyID, task)
+		assert.NoError(t, err, "%d: error adding task", i)
+
+		task, err = queue.PopNextTask(rc, queue.HandlerQueue)
+		assert.NoError(t, err, "%d: error popping next task", i)
+
+		err = handleContactEvent(ctx, db, rp, task)
+		assert.NoError(t, err, "%d: error when handling event", i)
+
+		testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND is_stopped = $2`, []interface{}{models.CathyID, tc.Stopped}, 1)
+		testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM channels_channelevent WHERE contact_id = $1 AND event_type = 'stop_contact'`, []interface{}{models.CathyID}, tc.OptOuts)
+		testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND metadata::jsonb->'consent'->>'action' = 'opt_in'`, []interface{}{models.CathyID}, tc.OptIns)
+
+		var text, direction string
+		db.QueryRow(`SELECT text, direction FROM msgs_msg WHERE contact_id = $1 ORDER BY id DESC LIMIT 1`, models.CathyID).Scan(&text, &direction)
+		assert.Equal(t, tc.Response, text, "%d: unexpected last message", i)
+		assert.Equal(t, tc.Direction, direction, "%d: unexpected last message direction", i)
+	}
+}
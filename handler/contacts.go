@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// contactQueue returns the key of the list of events waiting to be handled for the passed in contact
+func contactQueue(orgID models.OrgID, contactID models.ContactID) string {
+	return fmt.Sprintf("c:%d:%d", orgID, contactID)
+}
+
+// contactRetry returns the key which is set while a failed event for the passed in contact is waiting to be retried
+func contactRetry(orgID models.OrgID, contactID models.ContactID) string {
+	return fmt.Sprintf("c:%d:%d:retry", orgID, contactID)
+}
+
+// ContactEvent is an event waiting in a contact's queue to be handled
+type ContactEvent struct {
+	Index      int             `json:"index"`
+	Type       string          `json:"type"`
+	QueuedOn   time.Time       `json:"queued_on"`
+	ErrorCount int             `json:"error_count"`
+	Task       json.RawMessage `json:"task"`
+}
+
+// ContactEvents returns the events waiting to be handled for the passed in contact, in the order they will be handled
+func ContactEvents(rc redis.Conn, orgID models.OrgID, contactID models.ContactID, actor string) ([]*ContactEvent, error) {
+	values, err := redis.Strings(rc.Do("lrange", contactQueue(orgID, contactID), 0, -1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading contact events")
+	}
+
+	events := make([]*ContactEvent, len(values))
+	for i, value := range values {
+		task := &queue.Task{}
+		err = json.Unmarshal([]byte(value), task)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling contact event: %s", value)
+		}
+
+		events[i] = &ContactEvent{
+			Index:      i,
+			Type:       task.Type,
+			QueuedOn:   task.QueuedOn,
+			ErrorCount: task.ErrorCount,
+			Task:       task.Task,
+		}
+	}
+
+	auditLog(actor, "inspect", orgID, contactID).WithField("pending", len(events)).Info("inspected contact events")
+
+	return events, nil
+}
+
+var dropEventScript = redis.NewScript(3, `
+	-- KEYS: [ContactQueue, Index, Type]
+	local event = redis.call("lindex", KEYS[1], KEYS[2])
+	if not event or cjson.decode(event)["type"] ~= KEYS[3] then
+	  return 0
+	end
+
+	-- mark the event at our index so we can remove it and only it
+	redis.call("lset", KEYS[1], KEYS[2], "__dropped__")
+	return redis.call("lrem", KEYS[1], 1, "__dropped__")
+`)
+
+// DropContactEvent drops the event at the passed in index from the passed in contact's queue, returning whether it
+// was dropped. The event must be of the passed in type, so that we don't drop a different event if the queue has
+// changed since it was inspected. Indexes count from the front of the queue so can't be negative.
+func DropContactEvent(rc redis.Conn, orgID models.OrgID, contactID models.ContactID, index int, eventType string, actor string) (bool, error) {
+	if index < 0 {
+		return false, errors.Errorf("invalid contact event index: %d", index)
+	}
+
+	dropped, err := redis.Bool(dropEventScript.Do(rc, contactQueue(orgID, contactID), index, eventType))
+	if err != nil {
+		return false, errors.Wrapf(err, "error dropping contact event")
+	}
+
+	auditLog(actor, "drop_event", orgID, contactID).
+		WithField("index", index).WithField("event_type", eventType).WithField("dropped", dropped).
+		Info("dropped contact event")
+
+	return dropped, nil
+}
+
+// UnlockContact force releases the lock on the passed in contact, returning whether it was locked. If the contact
+// has events waiting to be handled, a task is queued to handle them.
+func UnlockContact(rp *redis.Pool, orgID models.OrgID, contactID models.ContactID, actor string) (bool, error) {
+	released, err := locker.ForceReleaseLock(rp, models.ContactLock(orgID, contactID))
+	if err != nil {
+		return false, err
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	pending, err := redis.Int(rc.Do("llen", contactQueue(orgID, contactID)))
+	if err != nil {
+		return false, errors.Wrapf(err, "error reading contact events")
+	}
+
+	if pending > 0 {
+		err = queue.QueueTask(rc, queue.HandlerQueue, queue.HandleContactEvent, int(orgID), &HandleEventTask{ContactID: contactID}, queue.DefaultPriority)
+		if err != nil {
+			return false, errors.Wrapf(err, "error adding handle event task")
+		}
+	}
+
+	auditLog(actor, "unlock", orgID, contactID).
+		WithField("released", released).WithField("pending", pending).
+		Info("force released contact lock")
+
+	return released, nil
+}
+
+// auditLog returns a log entry for recording an action taken on a contact's events by the passed in actor
+func auditLog(actor string, action string, orgID models.OrgID, contactID models.ContactID) *logrus.Entry {
+	return logrus.WithField("comp", "audit").
+		WithField("actor", actor).
+		WithField("action", action).
+		WithField("org_id", orgID).
+		WithField("contact_id", contactID)
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContactEvents(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	events, err := ContactEvents(rc, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(events))
+
+	assert.NoError(t, AddHandleTask(rc, models.CathyID, NewTimeoutTask(models.Org1, models.CathyID, 1, time.Now())))
+	assert.NoError(t, AddHandleTask(rc, models.CathyID, NewExpirationTask(models.Org1, models.CathyID, 1, 2, time.Now())))
+	assert.NoError(t, AddHandleTask(rc, models.CathyID, NewTimeoutTask(models.Org1, models.CathyID, 2, time.Now())))
+
+	events, err = ContactEvents(rc, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, TimeoutEventType, events[0].Type)
+	assert.Equal(t, ExpirationEventType, events[1].Type)
+	assert.Equal(t, 1, events[1].Index)
+
+	// can't drop an event if it isn't the type we expect
+	dropped, err := DropContactEvent(rc, models.Org1, models.CathyID, 1, TimeoutEventType, "tester")
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+
+	dropped, err = DropContactEvent(rc, models.Org1, models.CathyID, 5, TimeoutEventType, "tester")
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+
+	// or by a negative index, which redis would count from the end of the queue
+	_, err = DropContactEvent(rc, models.Org1, models.CathyID, -1, TimeoutEventType, "tester")
+	assert.Error(t, err)
+
+	dropped, err = DropContactEvent(rc, models.Org1, models.CathyID, 1, ExpirationEventType, "tester")
+	assert.NoError(t, err)
+	assert.True(t, dropped)
+
+	events, err = ContactEvents(rc, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, TimeoutEventType, events[1].Type)
+
+	// lock our contact as if its handler were stuck
+	lock, err := locker.GrabLock(rp, models.ContactLock(models.Org1, models.CathyID), time.Minute, 0)
+	assert.NoError(t, err)
+	assert.NotZero(t, lock)
+
+	// clear the handle tasks queued along with our events
+	rc.Do("del", "handler:1", "handler:active")
+
+	released, err := UnlockContact(rp, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.True(t, released)
+
+	locked, err := locker.IsLocked(rp, models.ContactLock(models.Org1, models.CathyID))
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	// a task should have been queued to handle the remaining events
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, queue.HandleContactEvent, task.Type)
+	}
+
+	released, err = UnlockContact(rp, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.False(t, released)
+}
+
+func TestContactEventRetry(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	ctx := testsuite.CTX()
+
+	// an event which failed is put back in front of the contact's other events with its retry pending
+	failed := NewTimeoutTask(models.Org1, models.CathyID, 1, time.Now())
+	assert.NoError(t, AddHandleTask(rc, models.CathyID, NewTimeoutTask(models.Org1, models.CathyID, 2, time.Now())))
+	assert.NoError(t, retryHandleTask(rc, models.CathyID, failed, time.Now().Add(time.Minute)))
+
+	// other tasks for the contact leave its events alone until the retry is due
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	assert.NoError(t, handleContactEvent(ctx, nil, rp, task))
+
+	events, err := ContactEvents(rc, models.Org1, models.CathyID, "tester")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+
+	// the retry itself is delayed rather than queued
+	task, err = queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/config"
 	"github.com/nyaruka/mailroom/cron"
-	"github.com/nyaruka/mailroom/marker"
+	"github.com/nyaruka/mailroom/idempotency"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/nyaruka/mailroom/queue"
 	"github.com/pkg/errors"
@@ -20,9 +20,11 @@ import (
 
 const (
 	retryLock = "retry_msgs"
-	markerKey = "retried_msgs"
 )
 
+// retriedMsgs is the group of msgs which have been retried, we only retry each msg once an hour
+var retriedMsgs = idempotency.NewGroup("retried_msgs", time.Hour)
+
 func init() {
 	mailroom.AddInitFunction(StartRetryCron)
 }
@@ -30,8 +32,8 @@ func init() {
 // StartRetryCron starts our cron job of retrying pending incoming messages
 func StartRetryCron(mr *mailroom.Mailroom) error {
 	cron.StartCron(mr.Quit, mr.RP, retryLock, time.Minute*5,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 			return retryPendingMsgs(ctx, mr.DB, mr.RP, lockName, lockValue)
 		},
@@ -52,7 +54,7 @@ func retryPendingMsgs(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName
 	defer rc.Close()
 
 	// check the size of our handle queue
-	handlerSize, err := queue.Size(rc, queue.HandlerQueue)
+	handlerSize, err := queue.Default(rp).Size(queue.HandlerQueue)
 	if err != nil {
 		return errors.Wrapf(err, "error finding size of handler queue")
 	}
@@ -82,16 +84,13 @@ func retryPendingMsgs(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName
 			return errors.Wrapf(err, "error scanning msg row")
 		}
 
-		// our key is built such that we will only retry once an hour
-		key := fmt.Sprintf("%d_%d", msgID, time.Now().Hour())
-
-		dupe, err := marker.HasTask(rc, markerKey, key)
+		// mark this msg as retried, skipping it if we already have within the hour
+		key := fmt.Sprintf("%d", msgID)
+		added, err := retriedMsgs.CheckAndAdd(rc, []string{key})
 		if err != nil {
-			return errors.Wrapf(err, "error checking for dupe retry")
+			return errors.Wrapf(err, "error marking msg for retry")
 		}
-
-		// we already retried this, skip
-		if dupe {
+		if len(added) == 0 {
 			continue
 		}
 
@@ -105,15 +104,10 @@ func retryPendingMsgs(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName
 		// queue this event up for handling
 		err = AddHandleTask(rc, contactID, task)
 		if err != nil {
+			retriedMsgs.Remove(rc, key)
 			return errors.Wrapf(err, "error queuing retry for task")
 		}
 
-		// mark it as queued
-		err = marker.AddTask(rc, markerKey, key)
-		if err != nil {
-			return errors.Wrapf(err, "error marking task for retry")
-		}
-
 		retried++
 	}
 
@@ -4,7 +4,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/goflow/flows"
 
 	_ "github.com/nyaruka/mailroom/hooks"
 	"github.com/nyaruka/mailroom/models"
@@ -27,22 +27,27 @@ func TestRetryMsgs(t *testing.T) {
 	assert.NoError(t, err)
 
 	testMsgs := []struct {
+		UUID      flows.MsgUUID
 		Text      string
 		Status    models.MsgStatus
 		CreatedOn time.Time
 	}{
-		{"pending", models.MsgStatusPending, time.Now().Add(-time.Hour)},
-		{"handled", models.MsgStatusHandled, time.Now().Add(-time.Hour)},
-		{"recent", models.MsgStatusPending, time.Now()},
+		{"1fe2ba6b-66ad-4eab-9b4c-4e8a0f24c3bd", "pending", models.MsgStatusPending, time.Now().Add(-time.Hour)},
+		{"6e0a4f0c-0ea4-4ee8-a4a1-1b9bd8a2c4e5", "handled", models.MsgStatusHandled, time.Now().Add(-time.Hour)},
+		{"c3a2b1e6-95e1-4d43-8e64-5f8f9d1b0a7c", "recent", models.MsgStatusPending, time.Now()},
 	}
 
 	for _, msg := range testMsgs {
 		db.MustExec(
 			`INSERT INTO msgs_msg(uuid, org_id, channel_id, contact_id, contact_urn_id, text, direction, status, created_on, visibility, msg_count, error_count, next_attempt) 
 						   VALUES($1,   $2,     $3,         $4,         $5,             $6,   $7,        $8,     $9,         'V',        1,         0,           NOW())`,
-			utils.NewUUID(), models.Org1, models.TwilioChannelID, models.CathyID, models.CathyURNID, msg.Text, models.DirectionIn, msg.Status, msg.CreatedOn)
+			msg.UUID, models.Org1, models.TwilioChannelID, models.CathyID, models.CathyURNID, msg.Text, models.DirectionIn, msg.Status, msg.CreatedOn)
 	}
 
+	// the pending message was seen before, such as by a handler which was killed while handling it
+	_, _, err = checkMsgDuplicate(rc, models.ChannelType("T"), &MsgEvent{MsgUUID: testMsgs[0].UUID})
+	assert.NoError(t, err)
+
 	err = retryPendingMsgs(ctx, db, rp, "test", "test")
 	assert.NoError(t, err)
 
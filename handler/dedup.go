@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/librato"
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/idempotency"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	// msgNew is a message we haven't seen before
+	msgNew = "new"
+
+	// msgRedelivered is a message we have already seen, such as one queued again by our retrier. If it is still
+	// pending then we didn't finish handling it and it should be handled again.
+	msgRedelivered = "redelivered"
+
+	// msgDuplicate is a different message with the same external id on the same channel, such as when a channel
+	// retries sending us a message and courier creates it again
+	msgDuplicate = "duplicate"
+)
+
+var dedupWindows = map[string]time.Duration{"default": time.Hour}
+var dedupGroups = make(map[string]*idempotency.Group)
+var dedupMutex sync.Mutex
+
+func init() {
+	mailroom.AddInitFunction(configureDedup)
+}
+
+// configureDedup reads how long we remember incoming messages for each channel type from our config
+func configureDedup(mr *mailroom.Mailroom) error {
+	windows, err := mr.Config.DedupWindowsByChannelType()
+	if err != nil {
+		return err
+	}
+
+	dedupMutex.Lock()
+	defer dedupMutex.Unlock()
+
+	dedupWindows = windows
+	dedupGroups = make(map[string]*idempotency.Group)
+	return nil
+}
+
+// dedupGroup returns the group of incoming messages we've seen for the passed in channel type, or nil if we don't
+// deduplicate messages for that channel type
+func dedupGroup(channelType models.ChannelType) *idempotency.Group {
+	dedupMutex.Lock()
+	defer dedupMutex.Unlock()
+
+	window, found := dedupWindows[string(channelType)]
+	if !found {
+		window = dedupWindows["default"]
+	}
+	if window <= 0 {
+		return nil
+	}
+
+	group := dedupGroups[string(channelType)]
+	if group == nil {
+		group = idempotency.NewGroup(fmt.Sprintf("msgs_%s", channelType), window)
+		dedupGroups[string(channelType)] = group
+	}
+	return group
+}
+
+// checkMsgDuplicate records that we've seen the passed in message, returning whether it is new, redelivered or a
+// duplicate, and the keys recorded for it, which should be removed if handling it fails so that it can be retried
+func checkMsgDuplicate(rc redis.Conn, channelType models.ChannelType, event *MsgEvent) (string, []string, error) {
+	group := dedupGroup(channelType)
+	if group == nil {
+		return msgNew, nil, nil
+	}
+
+	keys := msgKeys(event)
+	uuidKey := keys[0]
+
+	added, err := group.CheckAndAdd(rc, keys)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error checking for duplicate msg")
+	}
+
+	if len(added) == len(keys) {
+		return msgNew, added, nil
+	}
+
+	result := msgDuplicate
+	if len(added) == 0 || added[0] != uuidKey {
+		result = msgRedelivered
+	}
+
+	librato.Gauge(fmt.Sprintf("mr.msg_%s", result), 1)
+	librato.Gauge(fmt.Sprintf("mr.msg_%s_%s", result, channelType), 1)
+
+	return result, added, nil
+}
+
+// msgKeys returns the keys we record for the passed in message, its UUID first
+func msgKeys(event *MsgEvent) []string {
+	keys := []string{fmt.Sprintf("uuid:%s", event.MsgUUID)}
+	if event.MsgExternalID != "" {
+		keys = append(keys, fmt.Sprintf("ext:%d:%s", event.ChannelID, event.MsgExternalID))
+	}
+	return keys
+}
+
+// forgetMsg removes the passed in keys recorded for a message whose handling failed
+func forgetMsg(rc redis.Conn, channelType models.ChannelType, keys []string) error {
+	group := dedupGroup(channelType)
+	if group == nil {
+		return nil
+	}
+	return group.Remove(rc, keys...)
+}
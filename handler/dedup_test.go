@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/null"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgDedup(t *testing.T) {
+	testsuite.ResetRP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	dedupWindows = map[string]time.Duration{"default": time.Hour, "TG": 0}
+	defer func() { dedupWindows = map[string]time.Duration{"default": time.Hour} }()
+
+	msg1 := &MsgEvent{ChannelID: 1, MsgUUID: flows.MsgUUID("1ae96956-4b34-433e-8d1a-f05fe6923d6d"), MsgExternalID: null.String("ext1")}
+	msg2 := &MsgEvent{ChannelID: 1, MsgUUID: flows.MsgUUID("a6e6f1ec-1f35-4c1f-9c2b-9b8e9f3c0b2d"), MsgExternalID: null.String("ext1")}
+	msg3 := &MsgEvent{ChannelID: 2, MsgUUID: flows.MsgUUID("f5a3f3c4-5b1e-4d38-a0c2-8a1c1f0a8f5e"), MsgExternalID: null.String("ext1")}
+	msg4 := &MsgEvent{ChannelID: 2, MsgUUID: flows.MsgUUID("0e2ab3c1-6d0a-4b37-9b2a-2d3e6f1f5d7c")}
+
+	tcs := []struct {
+		ChannelType models.ChannelType
+		Event       *MsgEvent
+		Result      string
+	}{
+		{"EX", msg1, msgNew},
+		{"EX", msg1, msgRedelivered},
+		{"EX", msg2, msgDuplicate},
+		{"EX", msg2, msgRedelivered},
+		{"EX", msg3, msgNew},
+		{"EX", msg4, msgNew},
+		{"EX", msg4, msgRedelivered},
+		{"TG", msg1, msgNew},
+		{"TG", msg1, msgNew},
+	}
+
+	for i, tc := range tcs {
+		result, _, err := checkMsgDuplicate(rc, tc.ChannelType, tc.Event)
+		assert.NoError(t, err, "%d: unexpected error", i)
+		assert.Equal(t, tc.Result, result, "%d: unexpected result", i)
+	}
+
+	// a message we fail to handle is forgotten so that it can be retried
+	msg5 := &MsgEvent{ChannelID: 1, MsgUUID: flows.MsgUUID("9d0e7c5a-2f4b-4e8a-b1c3-5a6d7e8f9a0b"), MsgExternalID: null.String("ext5")}
+	result, keys, err := checkMsgDuplicate(rc, "EX", msg5)
+	assert.NoError(t, err)
+	assert.Equal(t, msgNew, result)
+	assert.NoError(t, forgetMsg(rc, "EX", keys))
+
+	result, _, err = checkMsgDuplicate(rc, "EX", msg5)
+	assert.NoError(t, err)
+	assert.Equal(t, msgNew, result)
+}
@@ -449,3 +449,48 @@ func TestTimedEvents(t *testing.T) {
 		last = time.Now()
 	}
 }
+
+// insertTestMsg inserts a pending incoming message from the passed in contact on the Twitter channel
+func insertTestMsg(t *testing.T, contactID models.ContactID, urnID models.URNID, text string) (flows.MsgID, flows.MsgUUID) {
+	msgUUID := flows.MsgUUID(utils.NewUUID())
+	var msgID flows.MsgID
+	err := testsuite.DB().Get(&msgID,
+		`INSERT INTO msgs_msg(uuid, org_id, channel_id, contact_id, contact_urn_id, text, direction, status, created_on, visibility, msg_count, error_count, next_attempt) 
+		               VALUES($1,   $2,     $3,         $4,         $5,             $6,   'I',       'P',    NOW(),      'V',        1,         0,           NOW()) RETURNING id`,
+		msgUUID, models.Org1, models.TwitterChannelID, contactID, urnID, text)
+	assert.NoError(t, err)
+	return msgID, msgUUID
+}
+
+func TestContactEventDeadLetters(t *testing.T) {
+	testsuite.ResetRP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	event := &queue.Task{Type: MsgEventType, OrgID: int(models.Org1), Task: json.RawMessage(`{}`), ErrorCount: 3}
+	err := addContactEventDeadLetter(rc, models.CathyID, event, fmt.Errorf("boom"))
+	assert.NoError(t, err)
+
+	letters, err := queue.DeadLetters(rc, queue.HandlerQueue, int(models.Org1), 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, 3, letters[0].Task.ErrorCount)
+
+	// requeuing the letter should give the wrapped event a fresh start as well
+	_, err = queue.RequeueDeadLetter(rc, queue.HandlerQueue, int(models.Org1), letters[0].ID)
+	assert.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, queue.HandleContactEvent, task.Type)
+	assert.Equal(t, 0, task.ErrorCount)
+
+	eventTask := &HandleEventTask{}
+	assert.NoError(t, json.Unmarshal(task.Task, eventTask))
+	assert.Equal(t, models.CathyID, eventTask.ContactID)
+	assert.Equal(t, MsgEventType, eventTask.Event.Type)
+	assert.Equal(t, 0, eventTask.Event.ErrorCount)
+
+	// and the original event is left as it was
+	assert.Equal(t, 3, event.ErrorCount)
+}
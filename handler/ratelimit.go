@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/librato"
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	contactRateKey = "msgrate:contact:%d"
+	channelRateKey = "msgrate:channel:%d"
+
+	// what a message is rate limited by
+	limitedByContact = "contact"
+	limitedByChannel = "channel"
+)
+
+// msgRateLimits are how many incoming messages a contact or channel can send us within the window
+type msgRateLimits struct {
+	window  time.Duration
+	contact int
+	channel int
+}
+
+var rateLimits = msgRateLimits{window: time.Minute, contact: 30}
+var rateLimitsMutex sync.RWMutex
+
+func init() {
+	mailroom.AddInitFunction(configureRateLimits)
+}
+
+// configureRateLimits reads our incoming message rate limits from our config
+func configureRateLimits(mr *mailroom.Mailroom) error {
+	if mr.Config.MsgRateWindow <= 0 {
+		return errors.Errorf("invalid msg rate window: %d", mr.Config.MsgRateWindow)
+	}
+
+	rateLimitsMutex.Lock()
+	defer rateLimitsMutex.Unlock()
+
+	rateLimits = msgRateLimits{
+		window:  time.Duration(mr.Config.MsgRateWindow) * time.Second,
+		contact: mr.Config.ContactMsgRateLimit,
+		channel: mr.Config.ChannelMsgRateLimit,
+	}
+	return nil
+}
+
+var recordMsgRateScript = redis.NewScript(-1, `
+	-- KEYS: [RateKey1, RateKey2, ...], ARGV: [Now, WindowStart, WindowMS, MsgUUID]
+	local counts = {}
+	for i = 1, #KEYS do
+	  redis.call("zremrangebyscore", KEYS[i], "-inf", ARGV[2])
+	  redis.call("zadd", KEYS[i], ARGV[1], ARGV[4])
+	  redis.call("pexpire", KEYS[i], ARGV[3])
+	  table.insert(counts, redis.call("zcard", KEYS[i]))
+	end
+	return counts
+`)
+
+// checkMsgRate records the passed in incoming message against the rates of its contact and channel, returning
+// whether it is limited by either, and if so whether this message is the one that went over the limit. Rates are
+// measured over a sliding window so contacts and channels are released as soon as their rate drops.
+func checkMsgRate(rc redis.Conn, event *MsgEvent) (string, bool, error) {
+	rateLimitsMutex.RLock()
+	limits := rateLimits
+	rateLimitsMutex.RUnlock()
+
+	keys := make([]interface{}, 0, 2)
+	maxes := make([]int, 0, 2)
+	limitedBy := make([]string, 0, 2)
+
+	if limits.contact > 0 {
+		keys = append(keys, fmt.Sprintf(contactRateKey, event.ContactID))
+		maxes = append(maxes, limits.contact)
+		limitedBy = append(limitedBy, limitedByContact)
+	}
+	if limits.channel > 0 && event.ChannelID != models.NilChannelID {
+		keys = append(keys, fmt.Sprintf(channelRateKey, event.ChannelID))
+		maxes = append(maxes, limits.channel)
+		limitedBy = append(limitedBy, limitedByChannel)
+	}
+	if len(keys) == 0 {
+		return "", false, nil
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	window := int64(limits.window / time.Millisecond)
+
+	args := append([]interface{}{len(keys)}, keys...)
+	args = append(args, now, now-window, window, string(event.MsgUUID))
+
+	counts, err := redis.Ints(recordMsgRateScript.Do(rc, args...))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "error recording msg rate")
+	}
+
+	for i, count := range counts {
+		if count > maxes[i] {
+			return limitedBy[i], count == maxes[i]+1, nil
+		}
+	}
+	return "", false, nil
+}
+
+// handleRateLimitedMsg handles a message from a contact or on a channel which is over its rate limit, by putting it
+// in the inbox without it resuming or triggering any flow. When the contact or channel first goes over its limit,
+// we record that in the channel's log so that the org can see why its messages aren't being handled.
+func handleRateLimitedMsg(ctx context.Context, db *sqlx.DB, org *models.OrgAssets, event *MsgEvent, limitedBy string, newlyLimited bool, topup models.TopupID) error {
+	librato.Gauge(fmt.Sprintf("mr.msg_rate_limited_%s", limitedBy), 1)
+
+	err := models.UpdateMessage(ctx, db, event.MsgID, models.MsgStatusHandled, models.VisibilityVisible, models.TypeInbox, topup)
+	if err != nil {
+		return errors.Wrapf(err, "error marking rate limited message as handled")
+	}
+
+	if !newlyLimited {
+		return nil
+	}
+
+	description := fmt.Sprintf("Contact %d is sending too many messages, their messages will be handled as inbox only for now", event.ContactID)
+	if limitedBy == limitedByChannel {
+		description = "Channel is receiving too many messages, its messages will be handled as inbox only for now"
+	}
+
+	logrus.WithField("org_id", event.OrgID).WithField("contact_id", event.ContactID).WithField("channel_id", event.ChannelID).
+		WithField("limited_by", limitedBy).Warn("incoming messages rate limited")
+
+	channel := org.ChannelByID(event.ChannelID)
+	if channel == nil {
+		return nil
+	}
+
+	_, err = models.InsertChannelLog(ctx, db, description, true, "", "", nil, 0, nil, time.Now(), 0, channel, nil)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_id", event.ChannelID).Error("error logging rate limited msgs")
+	}
+	return nil
+}
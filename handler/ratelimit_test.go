@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgRateLimits(t *testing.T) {
+	testsuite.ResetRP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	rateLimits = msgRateLimits{window: time.Second, contact: 2, channel: 3}
+	defer func() { rateLimits = msgRateLimits{window: time.Minute, contact: 30} }()
+
+	newMsg := func(contactID models.ContactID, channelID models.ChannelID) *MsgEvent {
+		return &MsgEvent{ContactID: contactID, ChannelID: channelID, MsgUUID: flows.MsgUUID(utils.NewUUID())}
+	}
+
+	tcs := []struct {
+		Event        *MsgEvent
+		LimitedBy    string
+		NewlyLimited bool
+	}{
+		{newMsg(models.CathyID, models.TwitterChannelID), "", false},
+		{newMsg(models.CathyID, models.TwitterChannelID), "", false},
+		{newMsg(models.CathyID, models.TwitterChannelID), limitedByContact, true},
+		{newMsg(models.CathyID, models.TwitterChannelID), limitedByContact, false},
+		{newMsg(models.GeorgeID, models.TwilioChannelID), "", false},
+		{newMsg(models.GeorgeID, models.TwitterChannelID), limitedByChannel, false},
+		{newMsg(models.BobID, models.TwitterChannelID), limitedByChannel, false},
+	}
+
+	for i, tc := range tcs {
+		limitedBy, newlyLimited, err := checkMsgRate(rc, tc.Event)
+		assert.NoError(t, err, "%d: unexpected error", i)
+		assert.Equal(t, tc.LimitedBy, limitedBy, "%d: unexpected limited by", i)
+		assert.Equal(t, tc.NewlyLimited, newlyLimited, "%d: unexpected newly limited", i)
+	}
+
+	// once the window has passed, everyone is released
+	time.Sleep(time.Second + 100*time.Millisecond)
+
+	limitedBy, _, err := checkMsgRate(rc, newMsg(models.CathyID, models.TwitterChannelID))
+	assert.NoError(t, err)
+	assert.Equal(t, "", limitedBy)
+
+	// and no limits means nothing is recorded
+	rateLimits = msgRateLimits{window: time.Second}
+	limitedBy, _, err = checkMsgRate(rc, newMsg(models.CathyID, models.TwitterChannelID))
+	assert.NoError(t, err)
+	assert.Equal(t, "", limitedBy)
+}
+
+func TestRateLimitedOptOut(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	rp := testsuite.RP()
+	ctx := testsuite.CTX()
+
+	rateLimits = msgRateLimits{window: time.Minute, contact: 1}
+	defer func() { rateLimits = msgRateLimits{window: time.Minute, contact: 30} }()
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"opt_out_keywords": "stop"}' WHERE id = $1`, models.Org1)
+	models.FlushCache()
+
+	for i, text := range []string{"hello", "hello again", "stop"} {
+		msgID, msgUUID := insertTestMsg(t, models.CathyID, models.CathyURNID, text)
+
+		err := handleMsgEvent(ctx, db, rp, &MsgEvent{
+			ContactID: models.CathyID,
+			OrgID:     models.Org1,
+			ChannelID: models.TwitterChannelID,
+			MsgID:     msgID,
+			MsgUUID:   msgUUID,
+			URN:       models.CathyURN,
+			URNID:     models.CathyURNID,
+			Text:      text,
+		}, 0)
+		assert.NoError(t, err, "%d: error handling msg", i)
+	}
+
+	// our contact is over their limit but is still opted out by their last message
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND is_stopped = TRUE`, []interface{}{models.CathyID}, 1)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND text = 'hello again' AND msg_type = 'I' AND status = 'H'`, []interface{}{models.CathyID}, 1)
+}
+
+func TestRetriedMsgRate(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	rp := testsuite.RP()
+	ctx := testsuite.CTX()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	rateLimits = msgRateLimits{window: time.Minute, contact: 1}
+	defer func() { rateLimits = msgRateLimits{window: time.Minute, contact: 30} }()
+
+	for i, errorCount := range []int{0, 1} {
+		msgID, msgUUID := insertTestMsg(t, models.CathyID, models.CathyURNID, "hello")
+
+		err := handleMsgEvent(ctx, db, rp, &MsgEvent{
+			ContactID: models.CathyID,
+			OrgID:     models.Org1,
+			ChannelID: models.TwitterChannelID,
+			MsgID:     msgID,
+			MsgUUID:   msgUUID,
+			URN:       models.CathyURN,
+			URNID:     models.CathyURNID,
+			Text:      "hello",
+		}, errorCount)
+		assert.NoError(t, err, "%d: error handling msg", i)
+	}
+
+	// only the first attempt was counted against our contact's rate
+	count, err := redis.Int(rc.Do("zcard", fmt.Sprintf(contactRateKey, models.CathyID)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// buildReply builds a message with the passed in text replying to the passed in contact on the URN and channel they
+// messaged us on, or returns nil if there is no text. The message still needs to be inserted and queued to courier.
+func buildReply(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, contact *models.Contact, channel *models.Channel, urnID models.URNID, text string) (*models.Msg, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	urn := contact.URNForID(urnID)
+	if urn == urns.NilURN {
+		logrus.WithField("urn_id", urnID).WithField("contact_id", contact.ID()).Error("unable to find urn for reply")
+		return nil, nil
+	}
+
+	out := flows.NewMsgOut(urn, channel.ChannelReference(), text, nil, nil, nil)
+	msg, err := models.NewOutgoingMsg(org.OrgID(), channel, contact.ID(), out, time.Now())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating reply")
+	}
+
+	rc := rp.Get()
+	topup, err := models.DecrementOrgCredits(ctx, db, rc, org.OrgID(), 1)
+	rc.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding topup for reply")
+	}
+	msg.SetTopup(topup)
+
+	return msg, nil
+}
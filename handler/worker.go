@@ -36,8 +36,17 @@ const (
 	TimeoutEventType         = "timeout_event"
 )
 
+// eventRetryPolicy is how individual contact events which fail are retried, the contact's remaining events
+// wait behind the failed event until it is retried, other tasks for the contact stopping early while it is pending
+var eventRetryPolicy = &queue.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second * 5,
+	MaxBackoff:     time.Minute,
+	Multiplier:     4,
+}
+
 func init() {
-	mailroom.AddTaskFunction(queue.HandleContactEvent, handleEvent)
+	mailroom.AddTaskFunction(queue.HandleContactEvent, handleEvent, nil)
 }
 
 // AddHandleTask adds a single task for the passed in contact.
@@ -55,7 +64,7 @@ func addHandleTask(rc redis.Conn, contactID models.ContactID, task *queue.Task,
 	}
 
 	// first push the event on our contact queue
-	contactQ := fmt.Sprintf("c:%d:%d", task.OrgID, contactID)
+	contactQ := contactQueue(models.OrgID(task.OrgID), contactID)
 	if front {
 		_, err = redis.Int64(rc.Do("lpush", contactQ, string(taskJSON)))
 
@@ -70,7 +79,7 @@ func addHandleTask(rc redis.Conn, contactID models.ContactID, task *queue.Task,
 	contactTask := &HandleEventTask{ContactID: contactID}
 
 	// then add a handle task for that contact
-	err = queue.AddTask(rc, queue.HandlerQueue, queue.HandleContactEvent, task.OrgID, contactTask, queue.DefaultPriority)
+	err = queue.QueueTask(rc, queue.HandlerQueue, queue.HandleContactEvent, task.OrgID, contactTask, queue.DefaultPriority)
 	if err != nil {
 		return errors.Wrapf(err, "error adding handle event task")
 	}
@@ -84,29 +93,68 @@ func handleEvent(ctx context.Context, mr *mailroom.Mailroom, task *queue.Task) e
 // handleContactEvent is called when an event comes in for a contact.  to make sure we don't get into
 // a situation of being off by one, this task ingests and handles all the events for a contact, one by one
 func handleContactEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *queue.Task) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
-	defer cancel()
-
 	eventTask := &HandleEventTask{}
 	err := json.Unmarshal(task.Task, eventTask)
 	if err != nil {
 		return errors.Wrapf(err, "error decoding contact event task")
 	}
 
-	// acquire the lock for this contact
+	// acquire the lock for this contact, this is renewed for as long as we are handling events
 	lockID := models.ContactLock(models.OrgID(task.OrgID), eventTask.ContactID)
-	lock, err := locker.GrabLock(rp, lockID, time.Minute*5, time.Minute*5)
+	lock, err := locker.GrabRenewingLock(ctx, rp, lockID, time.Minute*5, time.Minute*5)
 	if err != nil {
 		return errors.Wrapf(err, "error acquiring lock for contact %d", eventTask.ContactID)
 	}
-	if lock == "" {
+	if lock == nil {
 		return errors.Errorf("unable to acquire lock for contact %d in timeout period, skipping", eventTask.ContactID)
 	}
-	defer locker.ReleaseLock(rp, lockID, lock)
+	defer lock.Release()
+
+	// if we lose our lock, stop handling events for this contact
+	ctx = lock.Context()
+
+	contactQ := contactQueue(models.OrgID(task.OrgID), eventTask.ContactID)
+
+	// if this task is carrying an event (a requeued dead letter), put it in front of any others for this contact
+	if eventTask.Event != nil {
+		eventJSON, err := json.Marshal(eventTask.Event)
+		if err != nil {
+			return errors.Wrapf(err, "error marshalling requeued contact event")
+		}
+		rc := rp.Get()
+		_, err = rc.Do("lpush", contactQ, string(eventJSON))
+		rc.Close()
+		if err != nil {
+			return errors.Wrapf(err, "error requeuing contact event")
+		}
+	}
+
+	// if a failed event for this contact is waiting to be retried, leave it and the events behind it to that retry
+	retryKey := contactRetry(models.OrgID(task.OrgID), eventTask.ContactID)
+	rc := rp.Get()
+	if eventTask.Retry {
+		_, err = rc.Do("del", retryKey)
+	} else {
+		var pending bool
+		pending, err = redis.Bool(rc.Do("exists", retryKey))
+		if err == nil && pending {
+			rc.Close()
+			logrus.WithField("contact_id", eventTask.ContactID).Debug("retry pending for contact, leaving events")
+			return nil
+		}
+	}
+	rc.Close()
+	if err != nil {
+		return errors.Wrapf(err, "error checking pending retry for contact %d", eventTask.ContactID)
+	}
 
 	// read all the events for this contact, one by one
-	contactQ := fmt.Sprintf("c:%d:%d", task.OrgID, eventTask.ContactID)
 	for {
+		// if we've lost our lock, stop, any remaining events will be handled by the next task for this contact
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "lost lock for contact %d", eventTask.ContactID)
+		}
+
 		// pop the next event off this contacts queue
 		rc := rp.Get()
 		event, err := redis.String(rc.Do("lpop", contactQ))
@@ -131,6 +179,9 @@ func handleContactEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *
 			return errors.Wrapf(err, "error unmarshalling contact event: %s", event)
 		}
 
+		// each event gets at most five minutes to be handled
+		eventCtx, cancel := context.WithTimeout(ctx, time.Minute*5)
+
 		// hand off to the appropriate handler
 		switch contactEvent.Type {
 
@@ -138,37 +189,43 @@ func handleContactEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *
 			evt := &StopEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
+				cancel()
 				return errors.Wrapf(err, "error unmarshalling stop event: %s", event)
 			}
-			err = handleStopEvent(ctx, db, rp, evt)
+			err = handleStopEvent(eventCtx, db, rp, evt)
 
 		case NewConversationEventType, ReferralEventType, MOMissEventType, WelcomeMessageEventType:
 			evt := &models.ChannelEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
+				cancel()
 				return errors.Wrapf(err, "error unmarshalling channel event: %s", event)
 			}
-			_, err = HandleChannelEvent(ctx, db, rp, models.ChannelEventType(contactEvent.Type), evt, nil)
+			_, err = HandleChannelEvent(eventCtx, db, rp, models.ChannelEventType(contactEvent.Type), evt, nil)
 
 		case MsgEventType:
 			msg := &MsgEvent{}
 			err = json.Unmarshal(contactEvent.Task, msg)
 			if err != nil {
+				cancel()
 				return errors.Wrapf(err, "error unmarshalling msg event: %s", event)
 			}
-			err = handleMsgEvent(ctx, db, rp, msg)
+			err = handleMsgEvent(eventCtx, db, rp, msg, contactEvent.ErrorCount)
 
 		case TimeoutEventType, ExpirationEventType:
 			evt := &TimedEvent{}
 			err = json.Unmarshal(contactEvent.Task, evt)
 			if err != nil {
+				cancel()
 				return errors.Wrapf(err, "error unmarshalling timeout event: %s", event)
 			}
-			err = handleTimedEvent(ctx, db, rp, contactEvent.Type, evt)
+			err = handleTimedEvent(eventCtx, db, rp, contactEvent.Type, evt)
 
 		default:
+			cancel()
 			return errors.Errorf("unknown contact event type: %s", contactEvent.Type)
 		}
+		cancel()
 
 		// log our processing time to librato
 		librato.Gauge(fmt.Sprintf("mr.%s_elapsed", contactEvent.Type), float64(time.Since(start))/float64(time.Second))
@@ -185,18 +242,27 @@ func handleContactEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, task *
 			})
 
 			contactEvent.ErrorCount++
-			if contactEvent.ErrorCount < 3 {
+			if eventRetryPolicy.ShouldRetry(contactEvent, err) {
+				backoff := eventRetryPolicy.Backoff(contactEvent.ErrorCount)
+
 				rc := rp.Get()
-				retryErr := addHandleTask(rc, eventTask.ContactID, contactEvent, true)
+				retryErr := retryHandleTask(rc, eventTask.ContactID, contactEvent, time.Now().Add(backoff))
 				if retryErr != nil {
 					logrus.WithError(retryErr).Error("error requeuing errored contact event")
 				}
 				rc.Close()
 
-				log.WithError(err).WithField("error_count", contactEvent.ErrorCount).Error("error handling contact event")
+				log.WithError(err).WithField("error_count", contactEvent.ErrorCount).WithField("backoff", backoff).Error("error handling contact event")
 				return nil
 			}
 			log.WithError(err).Error("error handling contact event, permanent failure")
+
+			rc := rp.Get()
+			deadErr := addContactEventDeadLetter(rc, eventTask.ContactID, contactEvent, err)
+			if deadErr != nil {
+				logrus.WithError(deadErr).Error("error adding dead letter for contact event")
+			}
+			rc.Close()
 			return nil
 		}
 	}
@@ -465,13 +531,95 @@ func handleStopEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *St
 	return err
 }
 
-// handleMsgEvent is called when a new message arrives from a contact
-func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *MsgEvent) error {
+// handleMsgEvent is called when a new message arrives from a contact, with the number of times handling it has errored
+func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *MsgEvent, errorCount int) error {
 	org, err := models.GetOrgAssets(ctx, db, event.OrgID)
 	if err != nil {
 		return errors.Wrapf(err, "error loading org")
 	}
 
+	var channelType models.ChannelType
+	if channel := org.ChannelByID(event.ChannelID); channel != nil {
+		channelType = channel.Type()
+	}
+
+	// check whether we've already seen this message
+	rc := rp.Get()
+	dedup, dedupKeys, err := checkMsgDuplicate(rc, channelType, event)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithField("msg_uuid", event.MsgUUID).WithField("msg_external_id", event.MsgExternalID)
+
+	// a redelivered message which is still pending is one we didn't finish handling, such as when we were killed
+	// while handling it, so handle it again and forget it again if that fails
+	retrying := errorCount > 0
+	if dedup == msgRedelivered {
+		status, err := models.GetMsgStatus(ctx, db, event.MsgID)
+		if err != nil {
+			return err
+		}
+		if status == models.MsgStatusPending {
+			log.Info("handling redelivered msg which is still pending")
+			dedup, dedupKeys = msgNew, msgKeys(event)
+			retrying = true
+		}
+	}
+
+	switch dedup {
+	case msgRedelivered:
+		log.Info("skipping already handled msg")
+		return nil
+
+	case msgDuplicate:
+		log.Info("skipping duplicate msg")
+		err := models.UpdateMessage(ctx, db, event.MsgID, models.MsgStatusHandled, models.VisibilityArchived, models.TypeInbox, models.NilTopupID)
+		if err != nil {
+			return errors.Wrapf(err, "error marking duplicate message as handled")
+		}
+		return nil
+	}
+
+	// if we don't finish handling this message, forget it so that it can be retried
+	handled := false
+	defer func() {
+		if !handled {
+			rc := rp.Get()
+			err := forgetMsg(rc, channelType, dedupKeys)
+			rc.Close()
+			if err != nil {
+				log.WithError(err).Error("error forgetting unhandled msg")
+			}
+		}
+	}()
+
+	// record this message against the rates of its contact and channel, those over their limit are only put in the inbox,
+	// but only on our first attempt so that retrying a message which errored doesn't limit its contact or channel
+	limitedBy, newlyLimited := "", false
+	if !retrying {
+		rc = rp.Get()
+		limitedBy, newlyLimited, err = checkMsgRate(rc, event)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = handleNewMsgEvent(ctx, db, rp, org, event, limitedBy, newlyLimited)
+	if err != nil {
+		return err
+	}
+
+	handled = true
+	return nil
+}
+
+// handleNewMsgEvent handles a message from a contact that we haven't seen before. If the contact or channel is over its
+// rate limit, the message is still checked for being from a blocked contact or being an opt out keyword, but otherwise
+// only put in the inbox.
+func handleNewMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, event *MsgEvent, limitedBy string, newlyLimited bool) error {
 	// find the topup for this message
 	rc := rp.Get()
 	topup, err := models.DecrementOrgCredits(ctx, db, rc, event.OrgID, 1)
@@ -527,6 +675,19 @@ func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *Msg
 		return nil
 	}
 
+	// opt out keyword, or opt in keyword from a stopped contact? that changes the contact's consent instead of being
+	// handled by any flow, whereas opt in keywords from other contacts are just replies
+	action, keyword := models.FindConsentKeyword(org, event.Text)
+	if action == models.OptOutAction || (action == models.OptInAction && modelContact.IsStopped()) {
+		return handleConsentKeyword(ctx, db, rp, org, modelContact, contact, channel, event, action, keyword, topup)
+	}
+
+	// contact or channel sending us too many messages? just put this message in the inbox
+	if limitedBy != "" {
+		logrus.WithField("msg_uuid", event.MsgUUID).WithField("limited_by", limitedBy).Info("handling rate limited msg as inbox only")
+		return handleRateLimitedMsg(ctx, db, org, event, limitedBy, newlyLimited, topup)
+	}
+
 	// stopped contact? they are unstopped if they send us an incoming message
 	newContact := event.NewContact
 	if modelContact.IsStopped() {
@@ -547,7 +708,7 @@ func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *Msg
 	}
 
 	// find any matching triggers
-	trigger := models.FindMatchingMsgTrigger(org, contact, event.Text)
+	trigger, match := models.FindMatchingMsgTrigger(org, contact, event.Text)
 
 	// get any active session for this contact
 	session, err := models.ActiveSessionForContact(ctx, db, org, models.MessagingFlow, contact)
@@ -613,7 +774,7 @@ func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *Msg
 			}
 
 			// otherwise build the trigger and start the flow directly
-			trigger := triggers.NewMsgTrigger(org.Env(), flow.FlowReference(), contact, msgIn, trigger.Match())
+			trigger := triggers.NewMsgTrigger(org.Env(), flow.FlowReference(), contact, msgIn, match)
 			_, err = runner.StartFlowForContacts(ctx, db, rp, org, sa, flow, []flows.Trigger{trigger}, hook, true)
 			if err != nil {
 				return errors.Wrapf(err, "error starting flow for contact")
@@ -632,6 +793,15 @@ func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *Msg
 		return nil
 	}
 
+	// nothing else handled this message, if the org is closed we may start a flow or auto reply
+	handled, err := handleClosedMsg(ctx, db, rp, org, sa, modelContact, contact, channel, msgIn, event, topup, hook)
+	if err != nil {
+		return errors.Wrapf(err, "error handling msg outside of business hours")
+	}
+	if handled {
+		return nil
+	}
+
 	err = models.UpdateMessage(ctx, db, event.MsgID, models.MsgStatusHandled, models.VisibilityVisible, models.TypeInbox, topup)
 	if err != nil {
 		return errors.Wrapf(err, "error marking message as handled")
@@ -639,8 +809,65 @@ func handleMsgEvent(ctx context.Context, db *sqlx.DB, rp *redis.Pool, event *Msg
 	return nil
 }
 
+// retryHandleTask puts the passed in failed task back in front of all other tasks for the contact and schedules
+// the contact's events to be handled again at runAt. Until then other tasks for the contact leave its events alone.
+func retryHandleTask(rc redis.Conn, contactID models.ContactID, task *queue.Task, runAt time.Time) error {
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling contact task")
+	}
+
+	contactQ := contactQueue(models.OrgID(task.OrgID), contactID)
+	_, err = rc.Do("lpush", contactQ, string(taskJSON))
+	if err != nil {
+		return errors.Wrapf(err, "error requeuing contact event")
+	}
+
+	// our retry is pending until it is due, if it runs late then the next task for the contact can handle it
+	pending := time.Until(runAt)
+	if pending < time.Millisecond {
+		pending = time.Millisecond
+	}
+	_, err = rc.Do("set", contactRetry(models.OrgID(task.OrgID), contactID), runAt.Unix(), "PX", int64(pending/time.Millisecond))
+	if err != nil {
+		return errors.Wrapf(err, "error marking contact retry pending")
+	}
+
+	err = queue.QueueDelayedTask(rc, queue.HandlerQueue, queue.HandleContactEvent, task.OrgID, &HandleEventTask{ContactID: contactID, Retry: true}, runAt)
+	if err != nil {
+		return errors.Wrapf(err, "error adding delayed handle event task")
+	}
+	return nil
+}
+
+// addContactEventDeadLetter records the passed in contact event as permanently failed. The event is wrapped in
+// a handle task for its contact so that requeuing the dead letter will handle it again for that contact. The
+// wrapped event starts over with no errors, the failures it has had are recorded on the dead letter's task.
+func addContactEventDeadLetter(rc redis.Conn, contactID models.ContactID, event *queue.Task, eventErr error) error {
+	requeued := *event
+	requeued.ErrorCount = 0
+
+	eventTaskJSON, err := json.Marshal(&HandleEventTask{ContactID: contactID, Event: &requeued})
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling contact event dead letter")
+	}
+
+	task := &queue.Task{
+		Type:       queue.HandleContactEvent,
+		OrgID:      event.OrgID,
+		Task:       eventTaskJSON,
+		QueuedOn:   event.QueuedOn,
+		ErrorCount: event.ErrorCount,
+	}
+
+	_, err = queue.QueueDeadLetter(rc, queue.HandlerQueue, task, eventErr.Error(), fmt.Sprintf("%+v", eventErr))
+	return err
+}
+
 type HandleEventTask struct {
 	ContactID models.ContactID `json:"contact_id"`
+	Event     *queue.Task      `json:"event,omitempty"`
+	Retry     bool             `json:"retry,omitempty"`
 }
 
 type TimedEvent struct {
@@ -52,7 +52,7 @@ func (h *StartBroadcastsHook) Apply(ctx context.Context, tx *sqlx.Tx, rp *redis.
 				priority = queue.HighPriority
 			}
 
-			err = queue.AddTask(rc, taskQ, queue.SendBroadcast, int(org.OrgID()), bcast, priority)
+			err = queue.QueueTask(rc, taskQ, queue.SendBroadcast, int(org.OrgID()), bcast, priority)
 			if err != nil {
 				return errors.Wrapf(err, "error queuing broadcast")
 			}
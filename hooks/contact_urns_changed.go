@@ -7,6 +7,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/mailroom/contacts"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -21,19 +22,64 @@ type CommitURNChangesHook struct{}
 
 var commitURNChangesHook = &CommitURNChangesHook{}
 
+// QueueURNMergesHook is our hook for queuing merges of contacts which had URNs taken by other contacts
+type QueueURNMergesHook struct{}
+
+var queueURNMergesHook = &QueueURNMergesHook{}
+
 // Apply adds all our URNS in a batch
 func (h *CommitURNChangesHook) Apply(ctx context.Context, tx *sqlx.Tx, rp *redis.Pool, org *models.OrgAssets, sessions map[*models.Session][]interface{}) error {
 	// gather all our urn changes, we only care about the last change for each session
 	changes := make([]*models.ContactURNsChanged, 0, len(sessions))
-	for _, sessionChanges := range sessions {
+	contactSessions := make(map[models.ContactID]*models.Session, len(sessions))
+	for session, sessionChanges := range sessions {
 		changes = append(changes, sessionChanges[len(sessionChanges)-1].(*models.ContactURNsChanged))
+		contactSessions[session.ContactID()] = session
 	}
 
-	err := models.UpdateContactURNs(ctx, tx, org, changes)
+	conflicts, err := models.UpdateContactURNs(ctx, tx, org, changes)
 	if err != nil {
 		return errors.Wrapf(err, "error updating contact urns")
 	}
 
+	// contacts which lost URNs to our contacts are now duplicates of them, merge them once we've committed
+	for _, conflict := range conflicts {
+		session := contactSessions[conflict.ContactID]
+		if session != nil {
+			session.AddPostCommitEvent(queueURNMergesHook, conflict)
+		}
+	}
+
+	return nil
+}
+
+// Apply queues a merge of each contact which lost a URN into the contact which took it
+func (h *QueueURNMergesHook) Apply(ctx context.Context, tx *sqlx.Tx, rp *redis.Pool, org *models.OrgAssets, sessions map[*models.Session][]interface{}) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	for _, es := range sessions {
+		// a contact could take several URNs from the same contact, only merge them once
+		losers := make(map[models.ContactID]bool, len(es))
+
+		for _, e := range es {
+			conflict := e.(*models.URNConflict)
+			if losers[conflict.PreviousContactID] {
+				continue
+			}
+			losers[conflict.PreviousContactID] = true
+
+			task := &contacts.MergeTask{SurvivorID: conflict.ContactID, LoserID: conflict.PreviousContactID}
+			err := contacts.QueueMerge(rc, org.OrgID(), task)
+			if err != nil {
+				return errors.Wrapf(err, "error queuing merge for urn conflict")
+			}
+
+			logrus.WithField("org_id", org.OrgID()).WithField("urn", conflict.Identity).WithField("survivor_id", conflict.ContactID).
+				WithField("loser_id", conflict.PreviousContactID).Info("queued merge for urn conflict")
+		}
+	}
+
 	return nil
 }
 
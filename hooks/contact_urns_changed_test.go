@@ -1,16 +1,22 @@
 package hooks
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/actions"
+	"github.com/nyaruka/mailroom/contacts"
 	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
 	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAddContactURN(t *testing.T) {
+	testsuite.ResetRP()
+
 	// add a URN to george that cathy will steal
 	db := testsuite.DB()
 	db.MustExec(
@@ -63,4 +69,24 @@ func TestAddContactURN(t *testing.T) {
 	}
 
 	RunActionTestCases(t, tcs)
+
+	// george is now a duplicate of cathy, so a merge of him into her should have been queued
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	if assert.NotNil(t, task) {
+		assert.Equal(t, queue.MergeContacts, task.Type)
+
+		mergeTask := &contacts.MergeTask{}
+		assert.NoError(t, json.Unmarshal(task.Task, mergeTask))
+		assert.Equal(t, models.CathyID, mergeTask.SurvivorID)
+		assert.Equal(t, models.GeorgeID, mergeTask.LoserID)
+	}
+
+	// and only the one merge
+	task, err = queue.PopNextTask(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
 }
@@ -46,7 +46,7 @@ func (h *StartStartHook) Apply(ctx context.Context, tx *sqlx.Tx, rp *redis.Pool,
 				priority = queue.HighPriority
 			}
 
-			err := queue.AddTask(rc, taskQ, queue.StartFlow, int(org.OrgID()), start, priority)
+			err := queue.QueueTask(rc, taskQ, queue.StartFlow, int(org.OrgID()), start, priority)
 			if err != nil {
 				return errors.Wrapf(err, "error queuing flow start")
 			}
@@ -0,0 +1,154 @@
+package idempotency
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const keyPattern = "idempotency:%s"
+
+// Group is a named set of keys, such as the ids of tasks which have been queued, each of which is remembered
+// for the group's TTL from when it was added. Keys are stored in a sorted set scored by when they were added.
+type Group struct {
+	name string
+	ttl  time.Duration
+}
+
+// NewGroup creates a new group with the passed in name whose keys are remembered for the passed in TTL
+func NewGroup(name string, ttl time.Duration) *Group {
+	return &Group{name: name, ttl: ttl}
+}
+
+// Name returns the name of this group
+func (g *Group) Name() string { return g.name }
+
+// TTL returns how long keys are remembered in this group
+func (g *Group) TTL() time.Duration { return g.ttl }
+
+func (g *Group) key() string { return fmt.Sprintf(keyPattern, g.name) }
+
+// args returns the arguments to our scripts, our set key, the current time, the time before which keys are
+// expired and the TTL of the set itself, all in milliseconds, followed by the passed in keys
+func (g *Group) args(keys []string) []interface{} {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	ttl := int64(g.ttl / time.Millisecond)
+
+	args := make([]interface{}, 0, len(keys)+4)
+	args = append(args, g.key(), now, now-ttl, ttl)
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	return args
+}
+
+var filterScript = redis.NewScript(1, `
+	-- KEYS: [SetKey], ARGV: [Now, ExpiredBefore, TTL, Key1, Key2, ...]
+	local absent = {}
+	for i = 4, #ARGV do
+	  local score = redis.call("zscore", KEYS[1], ARGV[i])
+	  if not score or tonumber(score) <= tonumber(ARGV[2]) then
+	    table.insert(absent, ARGV[i])
+	  end
+	end
+	return absent
+`)
+
+var addScript = redis.NewScript(1, `
+	-- KEYS: [SetKey], ARGV: [Now, ExpiredBefore, TTL, Key1, Key2, ...]
+	redis.call("zremrangebyscore", KEYS[1], "-inf", ARGV[2])
+	for i = 4, #ARGV do
+	  redis.call("zadd", KEYS[1], ARGV[1], ARGV[i])
+	end
+	redis.call("pexpire", KEYS[1], ARGV[3])
+	return 1
+`)
+
+var checkAndAddScript = redis.NewScript(1, `
+	-- KEYS: [SetKey], ARGV: [Now, ExpiredBefore, TTL, Key1, Key2, ...]
+	redis.call("zremrangebyscore", KEYS[1], "-inf", ARGV[2])
+	local added = {}
+	for i = 4, #ARGV do
+	  if redis.call("zadd", KEYS[1], "NX", ARGV[1], ARGV[i]) == 1 then
+	    table.insert(added, ARGV[i])
+	  end
+	end
+	redis.call("pexpire", KEYS[1], ARGV[3])
+	return added
+`)
+
+// Has returns whether the passed in key is present in this group
+func (g *Group) Has(rc redis.Conn, key string) (bool, error) {
+	absent, err := g.Filter(rc, []string{key})
+	if err != nil {
+		return false, err
+	}
+	return len(absent) == 0, nil
+}
+
+// Filter returns those of the passed in keys which are not present in this group
+func (g *Group) Filter(rc redis.Conn, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	absent, err := redis.Strings(filterScript.Do(rc, g.args(keys)...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking keys for group: %s", g.name)
+	}
+	return absent, nil
+}
+
+// Add adds the passed in keys to this group, resetting when they were added if already present
+func (g *Group) Add(rc redis.Conn, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := addScript.Do(rc, g.args(keys)...)
+	if err != nil {
+		return errors.Wrapf(err, "error adding keys to group: %s", g.name)
+	}
+	return nil
+}
+
+// CheckAndAdd atomically adds those of the passed in keys which are not already present in this group, returning
+// the keys which were added
+func (g *Group) CheckAndAdd(rc redis.Conn, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	added, err := redis.Strings(checkAndAddScript.Do(rc, g.args(keys)...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking and adding keys to group: %s", g.name)
+	}
+	return added, nil
+}
+
+// Remove removes the passed in keys from this group
+func (g *Group) Remove(rc redis.Conn, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, g.key())
+	for _, k := range keys {
+		args = append(args, k)
+	}
+
+	_, err := rc.Do("zrem", args...)
+	if err != nil {
+		return errors.Wrapf(err, "error removing keys from group: %s", g.name)
+	}
+	return nil
+}
+
+// Clear removes all keys from this group (mostly useful in unit testing)
+func (g *Group) Clear(rc redis.Conn) error {
+	_, err := rc.Do("del", g.key())
+	return err
+}
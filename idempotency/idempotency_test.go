@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	groups := map[string]*Group{
+		"1": NewGroup("1", time.Hour),
+		"2": NewGroup("2", time.Hour),
+	}
+
+	tcs := []struct {
+		Group  string
+		Key    string
+		Action string
+	}{
+		{"1", "1", "remove"},
+		{"2", "1", "remove"},
+		{"1", "2", "remove"},
+		{"1", "1", "absent"},
+		{"1", "1", "add"},
+		{"1", "1", "present"},
+		{"2", "1", "absent"},
+		{"1", "2", "absent"},
+		{"1", "1", "remove"},
+		{"1", "1", "absent"},
+	}
+
+	testsuite.ResetRP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	for i, tc := range tcs {
+		group := groups[tc.Group]
+
+		if tc.Action == "absent" {
+			present, err := group.Has(rc, tc.Key)
+			assert.NoError(t, err)
+			assert.False(t, present, "%d: %s:%s should be absent", i, tc.Group, tc.Key)
+		} else if tc.Action == "present" {
+			present, err := group.Has(rc, tc.Key)
+			assert.NoError(t, err)
+			assert.True(t, present, "%d: %s:%s should be present", i, tc.Group, tc.Key)
+		} else if tc.Action == "add" {
+			err := group.Add(rc, tc.Key)
+			assert.NoError(t, err)
+		} else if tc.Action == "remove" {
+			err := group.Remove(rc, tc.Key)
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestBatches(t *testing.T) {
+	testsuite.ResetRP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	group := NewGroup("batches", time.Hour)
+
+	absent, err := group.Filter(rc, []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, absent)
+
+	assert.NoError(t, group.Add(rc, "a", "b"))
+
+	absent, err = group.Filter(rc, []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, absent)
+
+	// only keys not yet present are added
+	added, err := group.CheckAndAdd(rc, []string{"b", "c", "d"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, added)
+
+	added, err = group.CheckAndAdd(rc, []string{"c", "d"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(added))
+
+	// keys are forgotten once they are older than our TTL
+	rc.Do("zadd", "idempotency:batches", time.Now().Add(-time.Hour*2).UnixNano()/int64(time.Millisecond), "a")
+
+	present, err := group.Has(rc, "a")
+	assert.NoError(t, err)
+	assert.False(t, present)
+
+	added, err = group.CheckAndAdd(rc, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, added)
+
+	// our set itself expires
+	ttl, err := rc.Do("pttl", "idempotency:batches")
+	assert.NoError(t, err)
+	assert.True(t, ttl.(int64) > 0)
+
+	assert.NoError(t, group.Clear(rc))
+	present, err = group.Has(rc, "b")
+	assert.NoError(t, err)
+	assert.False(t, present)
+}
@@ -28,16 +28,16 @@ func init() {
 // StartIVRCron starts our cron job of retrying errored calls
 func StartIVRCron(mr *mailroom.Mailroom) error {
 	cron.StartCron(mr.Quit, mr.RP, retryIVRLock, time.Minute,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 			return retryCalls(ctx, mr.Config, mr.DB, mr.RP, retryIVRLock, lockValue)
 		},
 	)
 
 	cron.StartCron(mr.Quit, mr.RP, expireIVRLock, time.Minute,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 			return expireCalls(ctx, mr.Config, mr.DB, mr.RP, expireIVRLock, lockValue)
 		},
@@ -16,7 +16,8 @@ import (
 )
 
 func init() {
-	mailroom.AddTaskFunction(queue.StartIVRFlowBatch, handleFlowStartTask)
+	// this isn't retried as calls may have already been requested for some contacts when it fails
+	mailroom.AddTaskFunction(queue.StartIVRFlowBatch, handleFlowStartTask, nil)
 }
 
 func handleFlowStartTask(ctx context.Context, mr *mailroom.Mailroom, task *queue.Task) error {
@@ -30,6 +31,18 @@ func handleFlowStartTask(ctx context.Context, mr *mailroom.Mailroom, task *queue
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
+	// if our start has been cancelled, skip this batch
+	rc := mr.RP.Get()
+	cancelled, err := models.IsFlowStartCancelled(rc, batch.StartID())
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		logrus.WithField("start_id", batch.StartID()).Info("skipping batch for cancelled ivr flow start")
+		return nil
+	}
+
 	return HandleFlowStartBatch(ctx, mr.Config, mr.DB, mr.RP, batch)
 }
 
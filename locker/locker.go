@@ -1,19 +1,92 @@
 package locker
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/librato"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-const sleep = time.Second * 1
+const (
+	// lockKey is the key of the lock itself, whose value identifies its owner
+	lockKey = "lock:%s"
+
+	// waitersKey is the list of values of those waiting on a lock, in the order they started waiting
+	waitersKey = "lockwaiters:%s"
+
+	// waiterKey is the expiring key that a waiter refreshes while it is still waiting
+	waiterKey = "lockwaiter:%s:%s"
+
+	// wakeKey is the list a waiter blocks on, which is pushed to when the lock is released and it is next in line
+	wakeKey = "lockwake:%s:%s"
+
+	// wakeTimeout is how many seconds a waiter blocks before checking the lock itself, in case it expired
+	wakeTimeout = 1
+)
+
+// pruneWaiters is the lua which removes waiters at the front of the line who are no longer waiting
+const pruneWaiters = `
+	local head = redis.call("lindex", KEYS[2], 0)
+	while head and redis.call("exists", "lockwaiter:" .. KEYS[3] .. ":" .. head) == 0 do
+	  redis.call("lpop", KEYS[2])
+	  head = redis.call("lindex", KEYS[2], 0)
+	end
+`
+
+// wakeHead is the lua which wakes the waiter at the front of the line, if any
+const wakeHead = `
+	if head then
+	  local wake = "lockwake:" .. KEYS[3] .. ":" .. head
+	  redis.call("rpush", wake, "1")
+	  redis.call("expire", wake, 5)
+	end
+`
+
+var grabScript = redis.NewScript(6, `
+    -- KEYS: [LockKey, WaitersKey, Key, Value, Expiration, Wait]
+`+pruneWaiters+`
+	-- we can only take the lock if nobody is ahead of us in line
+	if (not head or head == KEYS[4]) and redis.call("set", KEYS[1], KEYS[4], "EX", KEYS[5], "NX") then
+	  if head then
+	    redis.call("lpop", KEYS[2])
+	  end
+	  redis.call("del", "lockwaiter:" .. KEYS[3] .. ":" .. KEYS[4])
+	  return 1
+	end
+
+	-- otherwise get in line if we are waiting, and let others know we still are
+	if KEYS[6] == "1" then
+	  local waiter = "lockwaiter:" .. KEYS[3] .. ":" .. KEYS[4]
+	  if redis.call("exists", waiter) == 0 then
+	    redis.call("rpush", KEYS[2], KEYS[4])
+	  end
+	  redis.call("set", waiter, "1", "EX", 3)
+	end
+	return 0
+`)
+
+var leaveScript = redis.NewScript(4, `
+    -- KEYS: [LockKey, WaitersKey, Key, Value]
+	redis.call("lrem", KEYS[2], 0, KEYS[4])
+	redis.call("del", "lockwaiter:" .. KEYS[3] .. ":" .. KEYS[4], "lockwake:" .. KEYS[3] .. ":" .. KEYS[4])
+
+	-- if the lock is free, whoever is now next in line shouldn't wait on us
+	if redis.call("exists", KEYS[1]) == 0 then
+`+pruneWaiters+wakeHead+`
+	end
+	return 1
+`)
 
 // GrabLock grabs the passed in lock from redis in an atomic operation. It returns the lock value
 // if successful. It will retry until the retry period, returning empty string if not acquired
-// in that time.
+// in that time. Those waiting on a lock are given it in the order they started waiting, and are
+// woken as soon as it is released.
 func GrabLock(rp *redis.Pool, key string, expiration time.Duration, retry time.Duration) (string, error) {
 	// generate our lock value
 	value := makeRandom(10)
@@ -24,50 +97,191 @@ func GrabLock(rp *redis.Pool, key string, expiration time.Duration, retry time.D
 		return "", errors.Errorf("can't grab lock with expiration less than a second")
 	}
 
+	wait := 0
+	if retry > 0 {
+		wait = 1
+	}
+
 	start := time.Now()
+	waited := false
+
 	for {
 		rc := rp.Get()
-		success, err := rc.Do("SET", fmt.Sprintf("lock:%s", key), value, "EX", seconds, "NX")
+		success, err := redis.Int(grabScript.Do(rc, fmt.Sprintf(lockKey, key), fmt.Sprintf(waitersKey, key), key, value, seconds, wait))
 		rc.Close()
 
 		if err != nil {
 			return "", errors.Wrapf(err, "error trying to get lock")
 		}
 
-		if success == "OK" {
+		if success == 1 {
 			break
 		}
 
 		if time.Since(start) > retry {
+			if waited {
+				leaveLine(rp, key, value)
+				librato.Gauge("mr.lock_wait_ms", float64(time.Since(start))/float64(time.Millisecond))
+			}
 			return "", nil
 		}
 
-		time.Sleep(time.Second)
+		// wait until we are woken by the lock being released, or long enough that it may have expired
+		waited = true
+		rc = rp.Get()
+		_, err = rc.Do("BLPOP", fmt.Sprintf(wakeKey, key, value), wakeTimeout)
+		rc.Close()
+
+		if err != nil && err != redis.ErrNil {
+			leaveLine(rp, key, value)
+			return "", errors.Wrapf(err, "error waiting for lock")
+		}
+	}
+
+	if waited {
+		librato.Gauge("mr.lock_wait_ms", float64(time.Since(start))/float64(time.Millisecond))
 	}
 
 	return value, nil
 }
 
-var releaseScript = redis.NewScript(2, `
-    -- KEYS: [Key, Value]
-	if redis.call("get", KEYS[1]) == KEYS[2] then
-      return redis.call("del", KEYS[1])
-    else
-      return 0
-    end
+// leaveLine removes us from the line of those waiting on the passed in lock
+func leaveLine(rp *redis.Pool, key string, value string) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err := leaveScript.Do(rc, fmt.Sprintf(lockKey, key), fmt.Sprintf(waitersKey, key), key, value)
+	if err != nil {
+		logrus.WithError(err).WithField("lock", key).Error("error leaving lock line")
+	}
+}
+
+var releaseScript = redis.NewScript(4, `
+    -- KEYS: [LockKey, WaitersKey, Key, Value]
+	if redis.call("get", KEYS[1]) == KEYS[4] then
+	  redis.call("del", KEYS[1])
+`+pruneWaiters+wakeHead+`
+	  return 1
+	else
+	  return 0
+	end
 `)
 
 // ReleaseLock releases the passed in lock, returning any error encountered while doing
-// so. It is not considered an error to release a lock that is no longer present
+// so. It is not considered an error to release a lock that is no longer present. The first
+// of any waiting on the lock is woken so it can take it.
 func ReleaseLock(rp *redis.Pool, key string, value string) error {
 	rc := rp.Get()
 	defer rc.Close()
 
 	// we use lua here because we only want to release the lock if we own it
-	_, err := releaseScript.Do(rc, fmt.Sprintf("lock:%s", key), value)
+	_, err := releaseScript.Do(rc, fmt.Sprintf(lockKey, key), fmt.Sprintf(waitersKey, key), key, value)
 	return err
 }
 
+var grabAllScript = redis.NewScript(-1, `
+    -- KEYS: [Value, Expiration, Key1, Key2, ...]
+	local acquired = {}
+	for i = 3, #KEYS do
+	  local key = KEYS[i]
+	  local waiters = "lockwaiters:" .. key
+
+	  -- drop any waiters at the front of the line who are no longer waiting
+	  local head = redis.call("lindex", waiters, 0)
+	  while head and redis.call("exists", "lockwaiter:" .. key .. ":" .. head) == 0 do
+	    redis.call("lpop", waiters)
+	    head = redis.call("lindex", waiters, 0)
+	  end
+
+	  -- we don't jump the line of those waiting
+	  if not head and redis.call("set", "lock:" .. key, KEYS[1], "EX", KEYS[2], "NX") then
+	    table.insert(acquired, key)
+	  end
+	end
+	return acquired
+`)
+
+// GrabLocks tries to grab all the passed in locks at once without waiting, returning the keys of those acquired
+// and the value they were all acquired with. Locks held by others, or which others are waiting on, are skipped.
+func GrabLocks(rp *redis.Pool, keys []string, expiration time.Duration) ([]string, string, error) {
+	if len(keys) == 0 {
+		return nil, "", nil
+	}
+
+	// generate our lock value
+	value := makeRandom(10)
+
+	// convert our expiration to seconds
+	seconds := int(expiration / time.Second)
+	if seconds < 1 {
+		return nil, "", errors.Errorf("can't grab lock with expiration less than a second")
+	}
+
+	args := make([]interface{}, 0, len(keys)+3)
+	args = append(args, len(keys)+2, value, seconds)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	acquired, err := redis.Strings(grabAllScript.Do(rc, args...))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "error trying to get locks")
+	}
+
+	return acquired, value, nil
+}
+
+// ReleaseLocks releases all the passed in locks which were acquired together with the passed in value, waking
+// the first of any waiting on each
+func ReleaseLocks(rp *redis.Pool, keys []string, value string) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	for _, key := range keys {
+		releaseScript.Send(rc, fmt.Sprintf(lockKey, key), fmt.Sprintf(waitersKey, key), key, value)
+	}
+	_, err := rc.Do("")
+	if err != nil {
+		return errors.Wrapf(err, "error releasing locks")
+	}
+	return nil
+}
+
+var forceReleaseScript = redis.NewScript(3, `
+    -- KEYS: [LockKey, WaitersKey, Key]
+	local released = redis.call("del", KEYS[1])
+`+pruneWaiters+wakeHead+`
+	return released
+`)
+
+// ForceReleaseLock releases the passed in lock regardless of who holds it, returning whether it was held. This
+// should only be used to recover from a lock whose owner is stuck.
+func ForceReleaseLock(rp *redis.Pool, key string) (bool, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	released, err := redis.Bool(forceReleaseScript.Do(rc, fmt.Sprintf(lockKey, key), fmt.Sprintf(waitersKey, key), key))
+	if err != nil {
+		return false, errors.Wrapf(err, "error force releasing lock")
+	}
+	return released, nil
+}
+
+// IsLocked returns whether the passed in lock is currently held by anybody
+func IsLocked(rp *redis.Pool, key string) (bool, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	locked, err := redis.Bool(rc.Do("exists", fmt.Sprintf(lockKey, key)))
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking lock")
+	}
+	return locked, nil
+}
+
 var expireScript = redis.NewScript(3, `
     -- KEYS: [Key, Value, Expiration]
 	  if redis.call("get", KEYS[1]) == KEYS[2] then
@@ -79,20 +293,116 @@ var expireScript = redis.NewScript(3, `
 
 // ExtendLock extends our lock expiration by the passed in number of seconds
 func ExtendLock(rp *redis.Pool, key string, value string, expiration time.Duration) error {
+	_, err := extendLock(rp, key, value, expiration)
+	return err
+}
+
+// extendLock extends our lock expiration, returning whether we still owned the lock to extend
+func extendLock(rp *redis.Pool, key string, value string, expiration time.Duration) (bool, error) {
 	rc := rp.Get()
 	defer rc.Close()
 
 	// convert our expiration to seconds
 	seconds := int(expiration / time.Second)
 	if seconds < 1 {
-		return errors.Errorf("can't grab lock with expiration less than a second")
+		return false, errors.Errorf("can't grab lock with expiration less than a second")
 	}
 
 	// we use lua here because we only want to set the expiration time if we own it
-	_, err := expireScript.Do(rc, fmt.Sprintf("lock:%s", key), value, seconds)
+	extended, err := redis.Int(expireScript.Do(rc, fmt.Sprintf(lockKey, key), value, seconds))
+	if err != nil {
+		return false, err
+	}
+	return extended == 1, nil
+}
+
+// Lock is a handle to a lock we hold which is renewed in the background until it is released. Its context is
+// cancelled if the lock is lost, so that work being done while holding it can be abandoned.
+type Lock struct {
+	rp         *redis.Pool
+	key        string
+	value      string
+	expiration time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	release chan bool
+	once    sync.Once
+}
+
+// GrabRenewingLock grabs the passed in lock like GrabLock, but returns a handle which extends the lock's expiration
+// every third of its expiration until released. It returns nil if the lock couldn't be acquired in the retry period.
+func GrabRenewingLock(ctx context.Context, rp *redis.Pool, key string, expiration time.Duration, retry time.Duration) (*Lock, error) {
+	value, err := GrabLock(rp, key, expiration, retry)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	lock := &Lock{
+		rp:         rp,
+		key:        key,
+		value:      value,
+		expiration: expiration,
+		release:    make(chan bool),
+	}
+	lock.ctx, lock.cancel = context.WithCancel(ctx)
+
+	go lock.renew()
+
+	return lock, nil
+}
+
+// Value returns the value of this lock, which identifies us as its owner
+func (l *Lock) Value() string { return l.value }
+
+// Context returns a context which is cancelled if we fail to renew this lock or once it is released
+func (l *Lock) Context() context.Context { return l.ctx }
+
+// Release stops renewing this lock and releases it, it is safe to call more than once
+func (l *Lock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.release)
+		l.cancel()
+		err = ReleaseLock(l.rp, l.key, l.value)
+	})
 	return err
 }
 
+// renew extends our lock every third of its expiration until it is released, cancelling our context if the lock
+// is lost or we are unable to extend it before it expires
+func (l *Lock) renew() {
+	log := logrus.WithField("lock", l.key)
+	lastRenewal := time.Now()
+
+	for {
+		select {
+		case <-l.release:
+			return
+
+		case <-time.After(l.expiration / 3):
+			extended, err := extendLock(l.rp, l.key, l.value, l.expiration)
+			if err == nil && !extended {
+				log.Error("lock lost before it could be renewed")
+				l.cancel()
+				return
+			}
+
+			if err != nil {
+				log.WithError(err).Error("error renewing lock")
+
+				// we can keep trying until our lock would have expired
+				if time.Since(lastRenewal) >= l.expiration {
+					l.cancel()
+					return
+				}
+				continue
+			}
+			lastRenewal = time.Now()
+		}
+	}
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 // makeRandom creates a random key of the length passed in
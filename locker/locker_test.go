@@ -1,9 +1,11 @@
 package locker
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/stretchr/testify/assert"
 )
@@ -46,3 +48,141 @@ func TestLocker(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotZero(t, v5)
 }
+
+func TestRenewingLock(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	lock, err := GrabRenewingLock(context.Background(), rp, "renewing", time.Second*3, 0)
+	assert.NoError(t, err)
+	assert.NotNil(t, lock)
+	assert.NotZero(t, lock.Value())
+
+	// can't grab it while we hold it
+	other, err := GrabRenewingLock(context.Background(), rp, "renewing", time.Second*3, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, other)
+
+	// wait for a renewal, we should still have our lock
+	time.Sleep(time.Millisecond * 1200)
+	assert.NoError(t, lock.Context().Err())
+
+	value, err := redis.String(rc.Do("get", "lock:renewing"))
+	assert.NoError(t, err)
+	assert.Equal(t, lock.Value(), value)
+
+	// have somebody else take our lock, our next renewal should fail and cancel our context
+	rc.Do("set", "lock:renewing", "stolen")
+	time.Sleep(time.Millisecond * 1200)
+	assert.Equal(t, context.Canceled, lock.Context().Err())
+
+	// releasing doesn't release the other owner's lock
+	assert.NoError(t, lock.Release())
+	assert.NoError(t, lock.Release())
+
+	value, err = redis.String(rc.Do("get", "lock:renewing"))
+	assert.NoError(t, err)
+	assert.Equal(t, "stolen", value)
+}
+
+func TestLockWaiters(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+
+	v1, err := GrabLock(rp, "waited", time.Second*5, 0)
+	assert.NoError(t, err)
+	assert.NotZero(t, v1)
+
+	// can't release somebody else's lock
+	assert.NoError(t, ReleaseLock(rp, "waited", "other"))
+
+	// start two waiters, one after the other
+	acquired := make(chan string, 2)
+	for _, name := range []string{"first", "second"} {
+		go func(name string) {
+			value, err := GrabLock(rp, "waited", time.Second*5, time.Second*5)
+			assert.NoError(t, err)
+			assert.NotZero(t, value)
+			acquired <- name
+			time.Sleep(time.Millisecond * 100)
+			ReleaseLock(rp, "waited", value)
+		}(name)
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	// somebody who isn't willing to wait can't jump the line even if the lock expires
+	rc := testsuite.RC()
+	defer rc.Close()
+	rc.Do("del", "lock:waited")
+
+	jumped, err := GrabLock(rp, "waited", time.Second*5, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, jumped)
+
+	// our first waiter gets the expired lock, and the second is woken as soon as it is released
+	assert.Equal(t, "first", <-acquired)
+	start := time.Now()
+	assert.Equal(t, "second", <-acquired)
+	assert.True(t, time.Since(start) < time.Millisecond*500)
+
+	// once the line is empty we can grab it again without waiting
+	time.Sleep(time.Millisecond * 200)
+	v2, err := GrabLock(rp, "waited", time.Second*5, 0)
+	assert.NoError(t, err)
+	assert.NotZero(t, v2)
+
+	// a waiter who gives up leaves the line
+	v3, err := GrabLock(rp, "waited", time.Second*5, time.Second)
+	assert.NoError(t, err)
+	assert.Zero(t, v3)
+
+	count, err := redis.Int(rc.Do("llen", "lockwaiters:waited"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestGrabLocks(t *testing.T) {
+	testsuite.ResetRP()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+
+	acquired, value, err := GrabLocks(rp, nil, time.Second*5)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(acquired))
+
+	// somebody holds lock 2, and somebody else is waiting on lock 3 which has just been released
+	v2, err := GrabLock(rp, "multi2", time.Second*5, 0)
+	assert.NoError(t, err)
+	rc.Do("rpush", "lockwaiters:multi3", "waiter")
+	rc.Do("set", "lockwaiter:multi3:waiter", "1", "EX", 5)
+
+	acquired, value, err = GrabLocks(rp, []string{"multi1", "multi2", "multi3", "multi4"}, time.Second*5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"multi1", "multi4"}, acquired)
+	assert.NotZero(t, value)
+
+	// can't grab our acquired locks individually
+	v1, err := GrabLock(rp, "multi1", time.Second*5, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, v1)
+
+	// release them all together, which leaves the other lock alone
+	assert.NoError(t, ReleaseLocks(rp, append(acquired, "multi2"), value))
+
+	held, err := redis.String(rc.Do("get", "lock:multi2"))
+	assert.NoError(t, err)
+	assert.Equal(t, v2, held)
+
+	exists, err := redis.Bool(rc.Do("exists", "lock:multi1", "lock:multi4"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// once our waiter gives up, we can grab lock 3
+	rc.Do("del", "lockwaiter:multi3:waiter")
+	acquired, _, err = GrabLocks(rp, []string{"multi2", "multi3"}, time.Second*5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"multi3"}, acquired)
+}
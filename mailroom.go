@@ -39,10 +39,13 @@ func AddInitFunction(initFunc InitFunction) {
 type TaskFunction func(ctx context.Context, mr *Mailroom, task *queue.Task) error
 
 var taskFunctions = make(map[string]TaskFunction)
+var retryPolicies = make(map[string]*queue.RetryPolicy)
 
-// AddTaskFunction adds an task function that will be called for a type of task
-func AddTaskFunction(taskType string, taskFunc TaskFunction) {
+// AddTaskFunction adds an task function that will be called for a type of task, if that function returns an
+// error the task will be retried according to the passed in retry policy, nil meaning it is never retried
+func AddTaskFunction(taskType string, taskFunc TaskFunction, retryPolicy *queue.RetryPolicy) {
 	taskFunctions[taskType] = taskFunc
+	retryPolicies[taskType] = retryPolicy
 }
 
 // Mailroom is a service for handling RapidPro events
@@ -52,13 +55,15 @@ type Mailroom struct {
 	RP       *redis.Pool
 	S3Client s3iface.S3API
 
+	// Queue is where our tasks are queued, defaults to redis if not set before starting
+	Queue queue.Backend
+
 	Quit      chan bool
 	CTX       context.Context
 	Cancel    context.CancelFunc
 	WaitGroup *sync.WaitGroup
 
-	batchForeman   *Foreman
-	handlerForeman *Foreman
+	foremen []*Foreman
 
 	webserver *web.Server
 }
@@ -71,8 +76,10 @@ func NewMailroom(config *config.Config) *Mailroom {
 		WaitGroup: &sync.WaitGroup{},
 	}
 	mr.CTX, mr.Cancel = context.WithCancel(context.Background())
-	mr.batchForeman = NewForeman(mr, queue.BatchQueue, config.BatchWorkers)
-	mr.handlerForeman = NewForeman(mr, queue.HandlerQueue, config.HandlerWorkers)
+	mr.foremen = []*Foreman{
+		NewForeman(mr, queue.BatchQueue, config.BatchMinWorkers, config.BatchWorkers),
+		NewForeman(mr, queue.HandlerQueue, config.HandlerMinWorkers, config.HandlerWorkers),
+	}
 
 	return mr
 }
@@ -83,6 +90,12 @@ func (mr *Mailroom) Start() error {
 		"state": "starting",
 	})
 
+	// add foremen for our named queues and route task types to them
+	err := mr.configureNamedQueues()
+	if err != nil {
+		return err
+	}
+
 	// parse and test our db config
 	dbURL, err := url.Parse(mr.Config.DB)
 	if err != nil {
@@ -105,6 +118,15 @@ func (mr *Mailroom) Start() error {
 	mr.DB.SetMaxOpenConns(mr.Config.DBPoolSize)
 	mr.DB.SetConnMaxLifetime(time.Minute * 30)
 
+	// every worker can need a db connection at once, so warn if our pool can't provide that
+	maxWorkers := 0
+	for _, foreman := range mr.foremen {
+		maxWorkers += foreman.maxWorkers
+	}
+	if mr.Config.DBPoolSize < maxWorkers {
+		log.WithField("db_pool_size", mr.Config.DBPoolSize).WithField("max_workers", maxWorkers).Warn("db pool is smaller than the total of our maximum workers")
+	}
+
 	// try connecting
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	err = mr.DB.PingContext(ctx)
@@ -116,40 +138,18 @@ func (mr *Mailroom) Start() error {
 	}
 
 	// parse and test our redis config
-	redisURL, err := url.Parse(mr.Config.Redis)
+	redisPool, err := NewRedisPool(mr.Config.Redis)
 	if err != nil {
-		return fmt.Errorf("unable to parse Redis URL '%s': %s", mr.Config.Redis, err)
+		return err
 	}
+	mr.RP = redisPool
 
-	// create our pool
-	redisPool := &redis.Pool{
-		Wait:        true,              // makes callers wait for a connection
-		MaxActive:   36,                // only open this many concurrent connections at once
-		MaxIdle:     4,                 // only keep up to this many idle
-		IdleTimeout: 240 * time.Second, // how long to wait before reaping a connection
-		Dial: func() (redis.Conn, error) {
-			conn, err := redis.Dial("tcp", fmt.Sprintf("%s", redisURL.Host))
-			if err != nil {
-				return nil, err
-			}
-
-			// send auth if required
-			if redisURL.User != nil {
-				pass, authRequired := redisURL.User.Password()
-				if authRequired {
-					if _, err := conn.Do("AUTH", pass); err != nil {
-						conn.Close()
-						return nil, err
-					}
-				}
-			}
-
-			// switch to the right DB
-			_, err = conn.Do("SELECT", strings.TrimLeft(redisURL.Path, "/"))
-			return conn, err
-		},
+	if mr.Queue == nil {
+		mr.Queue = queue.NewRedisBackend(mr.RP)
 	}
-	mr.RP = redisPool
+
+	// hooks, handlers and web endpoints queue their tasks to the same backend as our workers
+	queue.SetDefault(mr.Queue)
 
 	// test our redis connection
 	conn := redisPool.Get()
@@ -193,9 +193,10 @@ func (mr *Mailroom) Start() error {
 		librato.Start()
 	}
 
-	// init our foremen and start it
-	mr.batchForeman.Start()
-	mr.handlerForeman.Start()
+	// init our foremen and start them
+	for _, foreman := range mr.foremen {
+		foreman.Start()
+	}
 
 	// start our web server
 	mr.webserver = web.NewServer(mr.CTX, mr.Config, mr.DB, mr.RP, mr.S3Client, mr.WaitGroup)
@@ -206,11 +207,48 @@ func (mr *Mailroom) Start() error {
 	return nil
 }
 
+// NewRedisPool creates a new pool of connections to the redis described by the passed in URL
+func NewRedisPool(redisURL string) (*redis.Pool, error) {
+	parsedURL, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Redis URL '%s': %s", redisURL, err)
+	}
+
+	return &redis.Pool{
+		Wait:        true,              // makes callers wait for a connection
+		MaxActive:   48,                // only open this many concurrent connections at once, enough for our default workers
+		MaxIdle:     4,                 // only keep up to this many idle
+		IdleTimeout: 240 * time.Second, // how long to wait before reaping a connection
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", fmt.Sprintf("%s", parsedURL.Host))
+			if err != nil {
+				return nil, err
+			}
+
+			// send auth if required
+			if parsedURL.User != nil {
+				pass, authRequired := parsedURL.User.Password()
+				if authRequired {
+					if _, err := conn.Do("AUTH", pass); err != nil {
+						conn.Close()
+						return nil, err
+					}
+				}
+			}
+
+			// switch to the right DB
+			_, err = conn.Do("SELECT", strings.TrimLeft(parsedURL.Path, "/"))
+			return conn, err
+		},
+	}, nil
+}
+
 // Stop stops the mailroom service
 func (mr *Mailroom) Stop() error {
 	logrus.Info("mailroom stopping")
-	mr.batchForeman.Stop()
-	mr.handlerForeman.Stop()
+	for _, foreman := range mr.foremen {
+		foreman.Stop()
+	}
 	librato.Stop()
 	close(mr.Quit)
 	mr.Cancel()
@@ -219,6 +257,37 @@ func (mr *Mailroom) Stop() error {
 	mr.webserver.Stop()
 
 	mr.WaitGroup.Wait()
+	queue.SetDefault(nil)
 	logrus.Info("mailroom stopped")
 	return nil
 }
+
+// configureNamedQueues adds a foreman for each of our configured named queues and routes task types to them
+func (mr *Mailroom) configureNamedQueues() error {
+	queues, err := mr.Config.NamedQueues()
+	if err != nil {
+		return err
+	}
+
+	routes, err := mr.Config.NamedQueueRoutes()
+	if err != nil {
+		return err
+	}
+
+	for name, maxWorkers := range queues {
+		if name == queue.BatchQueue || name == queue.HandlerQueue {
+			return fmt.Errorf("invalid named queue '%s', name is reserved", name)
+		}
+		mr.foremen = append(mr.foremen, NewForeman(mr, name, 1, maxWorkers))
+	}
+
+	// make sure every route goes to a queue we will be working on
+	for taskType, name := range routes {
+		if queues[name] == 0 && name != queue.BatchQueue && name != queue.HandlerQueue {
+			return fmt.Errorf("invalid route for task type '%s', no such queue '%s'", taskType, name)
+		}
+	}
+	queue.SetRoutes(routes)
+
+	return nil
+}
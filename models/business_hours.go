@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/pkg/errors"
+)
+
+// BusinessHoursConfig is the org config key of an org's business hours, e.g.
+//
+//   {
+//     "schedule": [{"days": [1, 2, 3, 4, 5], "start": "08:00", "end": "17:00"}],
+//     "holidays": ["2019-12-25"],
+//     "reply": "Thanks for your message, we're closed right now but will get back to you when we open.",
+//     "reply_window": 86400
+//   }
+//
+// Instead of a reply, a flow_uuid can be given of a flow to start.
+const BusinessHoursConfig = "business_hours"
+
+// the default seconds within which we only reply once to each contact outside of business hours
+const defaultBusinessHoursReplyWindow = 24 * 60 * 60
+
+// BusinessHours is an org's weekly schedule of opening hours and holidays, in the org's timezone, along with how we
+// respond to messages which nothing else handles when the org is closed
+type BusinessHours struct {
+	Schedule []struct {
+		Days  []int  `json:"days"`
+		Start string `json:"start"`
+		End   string `json:"end"`
+	} `json:"schedule" validate:"required,min=1"`
+	Holidays    []string        `json:"holidays"`
+	Reply       string          `json:"reply"`
+	FlowUUID    assets.FlowUUID `json:"flow_uuid"`
+	ReplyWindow int             `json:"reply_window" validate:"min=0"`
+
+	windows  []*Window
+	holidays map[string]bool
+}
+
+// LoadBusinessHours reads the business hours for the passed in org, returning nil if it doesn't have any
+func LoadBusinessHours(org *OrgAssets) (*BusinessHours, error) {
+	hours := &BusinessHours{}
+	found, err := org.Org().ConfigObject(BusinessHoursConfig, hours)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	err = hours.parse()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid business hours for org: %d", org.OrgID())
+	}
+
+	return hours, nil
+}
+
+// parse parses our schedule and holidays
+func (b *BusinessHours) parse() error {
+	b.windows = make([]*Window, 0, len(b.Schedule))
+	for _, s := range b.Schedule {
+		window, err := NewWindow(s.Days, s.Start, s.End)
+		if err != nil {
+			return err
+		}
+		b.windows = append(b.windows, window)
+	}
+
+	b.holidays = make(map[string]bool, len(b.Holidays))
+	for _, h := range b.Holidays {
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return errors.Wrapf(err, "invalid holiday: %s", h)
+		}
+		b.holidays[h] = true
+	}
+	return nil
+}
+
+// IsOpen returns whether the passed in time is within these business hours in the passed in timezone
+func (b *BusinessHours) IsOpen(now time.Time, tz *time.Location) bool {
+	if b.holidays[now.In(tz).Format("2006-01-02")] {
+		return false
+	}
+
+	for _, w := range b.windows {
+		if w.Contains(now, tz) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplyWindowDuration returns the window within which we only respond once to each contact when closed
+func (b *BusinessHours) ReplyWindowDuration() time.Duration {
+	if b.ReplyWindow == 0 {
+		return defaultBusinessHoursReplyWindow * time.Second
+	}
+	return time.Duration(b.ReplyWindow) * time.Second
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessHours(t *testing.T) {
+	tz, _ := time.LoadLocation("Africa/Kigali")
+
+	hours := &BusinessHours{}
+	err := utils.UnmarshalAndValidate([]byte(`{
+		"schedule": [
+			{"days": [1, 2, 3, 4, 5], "start": "08:00", "end": "17:00"},
+			{"days": [6], "start": "09:00", "end": "12:00"}
+		],
+		"holidays": ["2019-12-25"],
+		"reply": "We're closed"
+	}`), hours)
+	assert.NoError(t, err)
+	assert.NoError(t, hours.parse())
+
+	tcs := []struct {
+		Now  time.Time
+		Open bool
+	}{
+		{time.Date(2019, 12, 23, 8, 0, 0, 0, tz), true},          // Monday morning
+		{time.Date(2019, 12, 23, 7, 59, 0, 0, tz), false},        // Monday before opening
+		{time.Date(2019, 12, 23, 17, 0, 0, 0, tz), false},        // Monday at closing
+		{time.Date(2019, 12, 23, 15, 30, 0, 0, time.UTC), false}, // Monday 17:30 in Kigali
+		{time.Date(2019, 12, 25, 10, 0, 0, 0, tz), false},        // Christmas
+		{time.Date(2019, 12, 28, 10, 0, 0, 0, tz), true},         // Saturday morning
+		{time.Date(2019, 12, 28, 13, 0, 0, 0, tz), false},        // Saturday afternoon
+		{time.Date(2019, 12, 29, 10, 0, 0, 0, tz), false},        // Sunday
+	}
+
+	for i, tc := range tcs {
+		assert.Equal(t, tc.Open, hours.IsOpen(tc.Now, tz), "%d: unexpected open for %s", i, tc.Now)
+	}
+
+	assert.Equal(t, 24*time.Hour, hours.ReplyWindowDuration())
+	hours.ReplyWindow = 3600
+	assert.Equal(t, time.Hour, hours.ReplyWindowDuration())
+
+	// invalid schedules and holidays error
+	hours = &BusinessHours{Holidays: []string{"2019-13-01"}}
+	assert.Error(t, hours.parse())
+
+	hours = &BusinessHours{}
+	err = utils.UnmarshalAndValidate([]byte(`{"schedule": [{"days": [7], "start": "08:00"}]}`), hours)
+	assert.NoError(t, err)
+	assert.Error(t, hours.parse())
+
+	err = utils.UnmarshalAndValidate([]byte(`{"schedule": []}`), &BusinessHours{})
+	assert.Error(t, err)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/null"
 )
 
@@ -18,6 +17,7 @@ const (
 	ReferralEventType        = ChannelEventType("referral")
 	MOMissEventType          = ChannelEventType("mo_miss")
 	MOCallEventType          = ChannelEventType("mo_call")
+	StopContactEventType     = ChannelEventType("stop_contact")
 )
 
 // ChannelEvent represents an event that occurred associated with a channel, such as a referral, missed call, etc..
@@ -75,7 +75,7 @@ RETURNING
 
 // Insert inserts this channel event to our DB. The ID of the channel event will be
 // set if no error is returned
-func (e *ChannelEvent) Insert(ctx context.Context, db *sqlx.DB) error {
+func (e *ChannelEvent) Insert(ctx context.Context, db Queryer) error {
 	return BulkSQL(ctx, "insert channel event", db, insertChannelEventSQL, []interface{}{&e.e})
 }
 
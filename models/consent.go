@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/nyaruka/goflow/utils"
+)
+
+// ConsentAction is the change in consent a contact asks for by sending an opt in or opt out keyword
+type ConsentAction string
+
+const (
+	NilConsentAction = ConsentAction("")
+	OptOutAction     = ConsentAction("opt_out")
+	OptInAction      = ConsentAction("opt_in")
+
+	// org config keys of the comma separated opt out and opt in keywords, which can be in any language
+	OptOutKeywordsConfig = "opt_out_keywords"
+	OptInKeywordsConfig  = "opt_in_keywords"
+
+	// org config keys of the optional messages we reply with to confirm an opt out or opt in
+	OptOutMessageConfig = "opt_out_message"
+	OptInMessageConfig  = "opt_in_message"
+)
+
+// ConfirmationConfig returns the org config key of the message we reply with to confirm this change in consent
+func (a ConsentAction) ConfirmationConfig() string {
+	if a == OptInAction {
+		return OptInMessageConfig
+	}
+	return OptOutMessageConfig
+}
+
+// FindConsentKeyword returns the change in consent asked for by the passed in message text, if it is one of the org's
+// opt out or opt in keywords, along with the keyword matched. The whole message must be the keyword, ignoring case,
+// accents and punctuation. Opt out keywords take precedence if a keyword is in both lists.
+func FindConsentKeyword(org *OrgAssets, text string) (ConsentAction, string) {
+	lang := org.Env().DefaultLanguage()
+
+	if keyword := matchConsentKeyword(lang, org.Org().ConfigValue(OptOutKeywordsConfig, ""), text); keyword != "" {
+		return OptOutAction, keyword
+	}
+	if keyword := matchConsentKeyword(lang, org.Org().ConfigValue(OptInKeywordsConfig, ""), text); keyword != "" {
+		return OptInAction, keyword
+	}
+	return NilConsentAction, ""
+}
+
+// matchConsentKeyword returns which of the passed in comma separated keywords the passed in text is, if any
+func matchConsentKeyword(lang utils.Language, keywords string, text string) string {
+	if keywords == "" {
+		return ""
+	}
+
+	words := utils.TokenizeString(normalizeKeyword(lang, text))
+	if len(words) == 0 {
+		return ""
+	}
+
+	for _, keyword := range strings.Split(keywords, ",") {
+		keyword = strings.TrimSpace(keyword)
+		if keyword != "" && wordsEqual(words, utils.TokenizeString(normalizeKeyword(lang, keyword))) {
+			return keyword
+		}
+	}
+	return ""
+}
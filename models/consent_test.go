@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchConsentKeyword(t *testing.T) {
+	tcs := []struct {
+		Keywords string
+		Text     string
+		Keyword  string
+	}{
+		{"", "stop", ""},
+		{"stop, arrêter", "STOP", "stop"},
+		{"stop, arrêter", "Arreter!", "arrêter"},
+		{"stop, arrêter", "please stop", ""},
+		{"stop,, unsubscribe", "unsubscribe", "unsubscribe"},
+		{"start again", "Start  again.", "start again"},
+		{"stop", "", ""},
+	}
+
+	for i, tc := range tcs {
+		assert.Equal(t, tc.Keyword, matchConsentKeyword(utils.Language("fra"), tc.Keywords, tc.Text), "%d: unexpected keyword", i)
+	}
+}
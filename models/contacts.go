@@ -183,9 +183,9 @@ func (c *Contact) URNForID(urnID URNID) urns.URN {
 
 // Unstop sets the is_stopped attribute to false for this contact
 func (c *Contact) Unstop(ctx context.Context, db *sqlx.DB) error {
-	_, err := db.ExecContext(ctx, `UPDATE contacts_contact SET is_stopped = FALSE, modified_on = NOW() WHERE id = $1`, c.id)
+	err := UnstopContact(ctx, db, c.id)
 	if err != nil {
-		return errors.Wrapf(err, "error unstopping contact")
+		return err
 	}
 	c.isStopped = false
 	return nil
@@ -409,7 +409,8 @@ func ContactIDsFromURNs(ctx context.Context, db *sqlx.DB, org *OrgAssets, assets
 	return urnMap, nil
 }
 
-// CreateContact creates a new contact for the passed in org with the passed in URNs
+// CreateContact creates a new contact for the passed in org with the passed in URNs. If the URN already belongs to
+// another contact, that contact is returned instead of creating a duplicate.
 func CreateContact(ctx context.Context, db *sqlx.DB, org *OrgAssets, assets flows.SessionAssets, urn urns.URN) (ContactID, error) {
 	// we have a URN, first try to look up the URN
 	tx, err := db.BeginTxx(ctx, nil)
@@ -443,6 +444,11 @@ func CreateContact(ctx context.Context, db *sqlx.DB, org *OrgAssets, assets flow
 				if err != nil || len(ids) == 0 {
 					return NilContactID, errors.Wrapf(err, "unable to load contact for urn: %s", urn)
 				}
+
+				// our new contact was rolled back, so there are no duplicates to merge
+				logrus.WithField("org_id", org.OrgID()).WithField("urn", urn.Identity()).WithField("contact_id", ids[urn]).
+					Info("urn conflict creating contact, using existing contact")
+
 				return ids[urn], nil
 			}
 		}
@@ -686,6 +692,26 @@ WHERE
 	id = $1
 `
 
+// UnstopContact unstops the contact with the passed in id. Unlike stopping, this doesn't restore the contact's
+// groups, so callers should recalculate their dynamic groups.
+func UnstopContact(ctx context.Context, tx Queryer, contactID ContactID) error {
+	_, err := tx.ExecContext(ctx, markContactUnstoppedSQL, contactID)
+	if err != nil {
+		return errors.Wrapf(err, "error unstopping contact")
+	}
+	return nil
+}
+
+const markContactUnstoppedSQL = `
+UPDATE
+	contacts_contact
+SET
+	is_stopped = FALSE,
+	modified_on = NOW()
+WHERE 
+	id = $1
+`
+
 // UpdatePreferredURN updates the URNs for the contact (if needbe) to have the passed in URN as top priority
 // with the passed in channel as the preferred channel
 func (c *Contact) UpdatePreferredURN(ctx context.Context, tx Queryer, org *OrgAssets, urnID URNID, channel *Channel) error {
@@ -739,7 +765,7 @@ func (c *Contact) UpdatePreferredURN(ctx context.Context, tx Queryer, org *OrgAs
 	}
 
 	// write our new state to the db
-	err := UpdateContactURNs(ctx, tx, org, []*ContactURNsChanged{change})
+	_, err := UpdateContactURNs(ctx, tx, org, []*ContactURNsChanged{change})
 	if err != nil {
 		return errors.Wrapf(err, "error updating urns for contact")
 	}
@@ -833,8 +859,18 @@ func UpdateContactModifiedOn(ctx context.Context, tx Queryer, contactIDs []Conta
 	return err
 }
 
-// UpdateContactURNs updates the contact urns in our database to match the passed in changes
-func UpdateContactURNs(ctx context.Context, tx Queryer, org *OrgAssets, changes []*ContactURNsChanged) error {
+// URNConflict is a URN which was added to a contact while it belonged to another contact, leaving those two contacts
+// as duplicates of each other
+type URNConflict struct {
+	Identity          urns.URN
+	ContactID         ContactID
+	PreviousContactID ContactID
+}
+
+// UpdateContactURNs updates the contact urns in our database to match the passed in changes. URNs which belong to
+// other contacts are moved to the contacts they are added to, and returned as conflicts so that the contacts can be
+// merged.
+func UpdateContactURNs(ctx context.Context, tx Queryer, org *OrgAssets, changes []*ContactURNsChanged) ([]*URNConflict, error) {
 	// keep track of all our inserts
 	inserts := make([]interface{}, 0, len(changes))
 
@@ -854,7 +890,7 @@ func UpdateContactURNs(ctx context.Context, tx Queryer, org *OrgAssets, changes
 			// parse our query
 			query, err := urn.Query()
 			if err != nil {
-				return errors.Wrapf(err, "error parsing query for urn: %s", urn)
+				return nil, errors.Wrapf(err, "error parsing query for urn: %s", urn)
 			}
 
 			// figure out if we have a channel
@@ -907,35 +943,52 @@ func UpdateContactURNs(ctx context.Context, tx Queryer, org *OrgAssets, changes
 	// first update existing URNs
 	err := BulkSQL(ctx, "updating contact urns", tx, updateContactURNsSQL, updates)
 	if err != nil {
-		return errors.Wrapf(err, "error updating urns")
+		return nil, errors.Wrapf(err, "error updating urns")
 	}
 
+	conflicts := make([]*URNConflict, 0)
+
 	if len(inserts) > 0 {
-		// find the unique ids of the contacts that may be affected by our URN inserts
+		// find the contacts that may be affected by our URN inserts, and which of our URNs they have
 		rows, err := tx.QueryxContext(ctx,
-			`SELECT contact_id FROM contacts_contacturn WHERE identity = ANY($1) AND org_id = $2 AND contact_id IS NOT NULL`,
+			`SELECT identity, contact_id FROM contacts_contacturn WHERE identity = ANY($1) AND org_id = $2 AND contact_id IS NOT NULL`,
 			pq.Array(identities), org.OrgID(),
 		)
 		if err != nil {
-			return errors.Wrapf(err, "error finding contacts for urns")
+			return nil, errors.Wrapf(err, "error finding contacts for urns")
 		}
 		defer rows.Close()
 
 		orphanedIDs := make([]ContactID, 0, len(inserts))
+		owners := make(map[string]ContactID, len(inserts))
 		for rows.Next() {
+			var identity string
 			var contactID ContactID
-			err := rows.Scan(&contactID)
+			err := rows.Scan(&identity, &contactID)
 			if err != nil {
-				return errors.Wrapf(err, "error reading orphaned contacts")
+				return nil, errors.Wrapf(err, "error reading orphaned contacts")
 			}
 			orphanedIDs = append(orphanedIDs, contactID)
+			owners[identity] = contactID
 		}
 
 		// then insert new urns, we do these one by one since we have to deal with conflicts
-		for _, insert := range inserts {
+		for _, i := range inserts {
+			insert := i.(*urnInsert)
 			_, err := tx.NamedExecContext(ctx, insertContactURNsSQL, insert)
 			if err != nil {
-				return errors.Wrapf(err, "error inserting new urns")
+				return nil, errors.Wrapf(err, "error inserting new urns")
+			}
+
+			// if this URN belonged to another contact, the two are now duplicates
+			owner, owned := owners[insert.Identity]
+			if owned && owner != insert.ContactID {
+				conflicts = append(conflicts, &URNConflict{
+					Identity:          urns.URN(insert.Identity),
+					ContactID:         insert.ContactID,
+					PreviousContactID: owner,
+				})
+				owners[insert.Identity] = insert.ContactID
 			}
 		}
 
@@ -943,13 +996,13 @@ func UpdateContactURNs(ctx context.Context, tx Queryer, org *OrgAssets, changes
 		if len(orphanedIDs) > 0 {
 			err := UpdateContactModifiedOn(ctx, tx, orphanedIDs)
 			if err != nil {
-				return errors.Wrapf(err, "error updating orphaned contacts")
+				return nil, errors.Wrapf(err, "error updating orphaned contacts")
 			}
 		}
 	}
 
 	// NOTE: caller needs to update modified on for this contact
-	return nil
+	return conflicts, nil
 }
 
 // urnUpdate is our object that represents a single contact URN update
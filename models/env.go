@@ -86,6 +86,59 @@ func (o *Org) ConfigValue(key string, def string) string {
 	return strVal
 }
 
+// ConfigObject reads the object value for the passed in config key into the passed in value, returning whether it
+// was found
+func (o *Org) ConfigObject(key string, v interface{}) (bool, error) {
+	val, found := o.config[key]
+	if !found || val == nil {
+		return false, nil
+	}
+
+	asJSON, err := json.Marshal(val)
+	if err != nil {
+		return false, errors.Wrapf(err, "error marshalling org config: %s", key)
+	}
+
+	err = utils.UnmarshalAndValidate(asJSON, v)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid org config: %s", key)
+	}
+	return true, nil
+}
+
+// LoadOrgIntConfigs loads the integer value of the passed in config key for all active orgs which have it set
+func LoadOrgIntConfigs(ctx context.Context, db sqlx.QueryerContext, key string) (map[OrgID]int, error) {
+	rows, err := db.QueryxContext(ctx, selectOrgIntConfigs, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading org configs for key: %s", key)
+	}
+	defer rows.Close()
+
+	values := make(map[OrgID]int)
+	for rows.Next() {
+		var orgID OrgID
+		var value int
+		err = rows.Scan(&orgID, &value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning org config for key: %s", key)
+		}
+		values[orgID] = value
+	}
+
+	return values, nil
+}
+
+const selectOrgIntConfigs = `
+SELECT 
+	id, 
+	(config::json->>$1)::int
+FROM 
+	orgs_org 
+WHERE 
+	is_active = TRUE AND 
+	config::json->>$1 ~ '^\d+$'
+`
+
 // loadOrg loads the org for the passed in id, returning any error encountered
 func loadOrg(ctx context.Context, db sqlx.Queryer, orgID OrgID) (*Org, error) {
 	start := time.Now()
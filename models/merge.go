@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// MergePrecedence is which contact's value is kept when both contacts being merged have one
+type MergePrecedence string
+
+const (
+	// MergeKeepSurvivor keeps the value of the contact which survives the merge
+	MergeKeepSurvivor = MergePrecedence("survivor")
+
+	// MergeKeepLoser keeps the value of the contact which is merged into the survivor
+	MergeKeepLoser = MergePrecedence("loser")
+
+	// MergeKeepNewest keeps the value of whichever contact was most recently modified
+	MergeKeepNewest = MergePrecedence("newest")
+)
+
+// MergeOptions are which contact's fields and language are kept when two contacts are merged. Either way values which
+// only one contact has are kept. The survivor's URNs always take priority over those of the loser.
+type MergeOptions struct {
+	Fields   MergePrecedence `json:"fields"   validate:"omitempty,eq=survivor|eq=loser|eq=newest"`
+	Language MergePrecedence `json:"language" validate:"omitempty,eq=survivor|eq=loser|eq=newest"`
+}
+
+// keepLoser returns whether the passed in precedence means we keep the loser's value
+func (p MergePrecedence) keepLoser(survivor *Contact, loser *Contact) bool {
+	switch p {
+	case MergeKeepLoser:
+		return true
+	case MergeKeepNewest:
+		return loser.ModifiedOn().After(survivor.ModifiedOn())
+	}
+	return false
+}
+
+// MergeContacts merges the loser contact into the survivor contact. The survivor gets the loser's URNs, static group
+// memberships, and fields and language according to the passed in options, and the loser's messages, runs, sessions,
+// channel events and calls are moved to the survivor. Any sessions the loser was in are interrupted first, and the
+// loser is then released. Dynamic groups of the survivor need recalculating once this is committed.
+func MergeContacts(ctx context.Context, tx *sqlx.Tx, org *OrgAssets, survivorID ContactID, loserID ContactID, options *MergeOptions) error {
+	if survivorID == loserID {
+		return errors.Errorf("can't merge contact %d into itself", survivorID)
+	}
+
+	contacts, err := LoadContacts(ctx, tx, org, []ContactID{survivorID, loserID})
+	if err != nil {
+		return errors.Wrapf(err, "error loading contacts to merge")
+	}
+
+	var survivor, loser *Contact
+	for _, c := range contacts {
+		if c.ID() == survivorID {
+			survivor = c
+		} else {
+			loser = c
+		}
+	}
+	if survivor == nil || loser == nil {
+		return errors.Errorf("unable to find contacts %d and %d to merge", survivorID, loserID)
+	}
+
+	// interrupt the loser's sessions so that the survivor doesn't end up with more than one
+	for _, sessionType := range []FlowType{MessagingFlow, IVRFlow} {
+		err = InterruptContactRuns(ctx, tx, sessionType, []flows.ContactID{flows.ContactID(loserID)}, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "error interrupting sessions of merged contact")
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, mergeContactValuesSQL, survivorID, loserID, org.OrgID(),
+		options.Fields.keepLoser(survivor, loser), options.Language.keepLoser(survivor, loser))
+	if err != nil {
+		return errors.Wrapf(err, "error merging contact values")
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return errors.Errorf("unable to find contacts %d and %d to merge in org %d", survivorID, loserID, org.OrgID())
+	}
+
+	err = Exec(ctx, "moving merged contact urns", tx, moveMergedURNsSQL, survivorID, loserID)
+	if err != nil {
+		return err
+	}
+
+	err = Exec(ctx, "merging contact groups", tx, mergeContactGroupsSQL, survivorID, loserID)
+	if err != nil {
+		return err
+	}
+
+	for _, sql := range moveMergedHistorySQL {
+		err = Exec(ctx, "moving merged contact history", tx, sql, survivorID, loserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// finally release the loser, who no longer has any URNs, groups, upcoming events or triggers
+	_, err = tx.ExecContext(ctx, deleteAllContactGroupsSQL, org.OrgID(), loserID)
+	if err != nil {
+		return errors.Wrapf(err, "error removing merged contact from groups")
+	}
+	_, err = tx.ExecContext(ctx, deleteUnfiredEventsSQL, loserID)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting unfired event fires of merged contact")
+	}
+	_, err = tx.ExecContext(ctx, deleteAllContactTriggersSQL, loserID)
+	if err != nil {
+		return errors.Wrapf(err, "error removing merged contact from triggers")
+	}
+
+	return Exec(ctx, "releasing merged contact", tx, releaseMergedContactSQL, loserID)
+}
+
+const mergeContactValuesSQL = `
+UPDATE
+	contacts_contact s
+SET
+	fields = CASE WHEN $4 THEN COALESCE(s.fields, '{}'::jsonb) || COALESCE(l.fields, '{}'::jsonb) ELSE COALESCE(l.fields, '{}'::jsonb) || COALESCE(s.fields, '{}'::jsonb) END,
+	language = CASE WHEN $5 THEN COALESCE(l.language, s.language) ELSE COALESCE(s.language, l.language) END,
+	name = COALESCE(NULLIF(s.name, ''), l.name),
+	modified_on = NOW()
+FROM
+	contacts_contact l
+WHERE
+	s.id = $1 AND
+	l.id = $2 AND
+	s.org_id = $3 AND
+	l.org_id = $3
+`
+
+// the loser's URNs are all shifted down by the same offset so they keep their order but come after those of the survivor
+const moveMergedURNsSQL = `
+UPDATE
+	contacts_contacturn
+SET
+	contact_id = $1,
+	priority = priority - GREATEST(0, COALESCE(
+		(SELECT MAX(priority) FROM contacts_contacturn WHERE contact_id = $2) -
+		(SELECT MIN(priority) FROM contacts_contacturn WHERE contact_id = $1) + 1, 0
+	))
+WHERE
+	contact_id = $2
+`
+
+// dynamic groups are left to be recalculated
+const mergeContactGroupsSQL = `
+INSERT INTO
+	contacts_contactgroup_contacts(contactgroup_id, contact_id)
+SELECT
+	gc.contactgroup_id,
+	$1
+FROM
+	contacts_contactgroup_contacts gc
+	JOIN contacts_contactgroup g ON g.id = gc.contactgroup_id
+WHERE
+	gc.contact_id = $2 AND
+	g.group_type = 'U' AND
+	g.query IS NULL AND
+	NOT EXISTS (SELECT 1 FROM contacts_contactgroup_contacts WHERE contactgroup_id = gc.contactgroup_id AND contact_id = $1)
+`
+
+var moveMergedHistorySQL = []string{
+	`UPDATE msgs_msg SET contact_id = $1 WHERE contact_id = $2`,
+	`UPDATE flows_flowrun SET contact_id = $1 WHERE contact_id = $2`,
+	`UPDATE flows_flowsession SET contact_id = $1 WHERE contact_id = $2`,
+	`UPDATE channels_channelevent SET contact_id = $1 WHERE contact_id = $2`,
+	`UPDATE channels_channelconnection SET contact_id = $1 WHERE contact_id = $2`,
+	`UPDATE campaigns_eventfire SET contact_id = $1 WHERE contact_id = $2 AND fired IS NOT NULL`,
+}
+
+const releaseMergedContactSQL = `
+UPDATE
+	contacts_contact
+SET
+	is_active = FALSE,
+	modified_on = NOW()
+WHERE
+	id = $1
+`
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeContacts(t *testing.T) {
+	testsuite.Reset()
+	db := testsuite.DB()
+	ctx := testsuite.CTX()
+
+	// cathy is a doctor who speaks french, george is a tester who speaks english and has a newer age
+	db.MustExec(`UPDATE contacts_contact SET language = 'fra', fields = jsonb_build_object($2::text, '{"text": "20"}'::jsonb, $3::text, '{"text": "F"}'::jsonb), modified_on = NOW() - INTERVAL '1 day' WHERE id = $1`, CathyID, AgeFieldUUID, GenderFieldUUID)
+	db.MustExec(`UPDATE contacts_contact SET language = 'eng', fields = jsonb_build_object($2::text, '{"text": "21"}'::jsonb), modified_on = NOW() WHERE id = $1`, GeorgeID, AgeFieldUUID)
+	db.MustExec(`INSERT INTO contacts_contactgroup_contacts(contactgroup_id, contact_id) VALUES($1, $2)`, TestersGroupID, GeorgeID)
+
+	// george has a second URN after his first
+	var georgeURN2ID URNID
+	err := db.Get(&georgeURN2ID,
+		`INSERT INTO contacts_contacturn(org_id, contact_id, scheme, path, identity, priority) 
+								  VALUES($1, $2, 'tel', '+12065551212', 'tel:+12065551212', (SELECT priority - 1 FROM contacts_contacturn WHERE id = $3)) RETURNING id`, Org1, GeorgeID, GeorgeURNID)
+	assert.NoError(t, err)
+
+	db.MustExec(
+		`INSERT INTO msgs_msg(uuid, org_id, channel_id, contact_id, contact_urn_id, text, direction, status, created_on, visibility, msg_count, error_count, next_attempt) 
+					   VALUES($1,   $2,     $3,         $4,         $5,             'hi', 'I',       'H',    NOW(),      'V',        1,         0,           NOW())`,
+		utils.NewUUID(), Org1, TwilioChannelID, GeorgeID, GeorgeURNID)
+
+	org, err := GetOrgAssets(ctx, db, Org1)
+	assert.NoError(t, err)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+
+	// can't merge a contact into itself, or with a contact in another org
+	assert.Error(t, MergeContacts(ctx, tx, org, CathyID, CathyID, &MergeOptions{}))
+	assert.Error(t, MergeContacts(ctx, tx, org, CathyID, Org2FredID, &MergeOptions{}))
+
+	err = MergeContacts(ctx, tx, org, CathyID, GeorgeID, &MergeOptions{Fields: MergeKeepNewest, Language: MergeKeepSurvivor})
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	// cathy has george's newer age, keeps her gender and her language
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND fields->$2->>'text' = '21' AND fields->$3->>'text' = 'F' AND language = 'fra'`, []interface{}{CathyID, AgeFieldUUID, GenderFieldUUID}, 1)
+
+	// george's URNs are now cathy's, after her own and still in the same order
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contacturn WHERE id = $1 AND contact_id = $2 AND priority < (SELECT priority FROM contacts_contacturn WHERE id = $3)`, []interface{}{GeorgeURNID, CathyID, CathyURNID}, 1)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contacturn WHERE id = $1 AND contact_id = $2 AND priority < (SELECT priority FROM contacts_contacturn WHERE id = $3)`, []interface{}{georgeURN2ID, CathyID, GeorgeURNID}, 1)
+
+	// cathy is in both groups, george is in none
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contact_id = $1 AND contactgroup_id = ANY(ARRAY[$2, $3]::int[])`, []interface{}{CathyID, DoctorsGroupID, TestersGroupID}, 2)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contactgroup_contacts g JOIN contacts_contactgroup cg ON cg.id = g.contactgroup_id WHERE contact_id = $1 AND cg.group_type = 'U'`, []interface{}{GeorgeID}, 0)
+
+	// george's messages are now cathy's, and george is released
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1`, []interface{}{GeorgeID}, 0)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND contact_urn_id = $2`, []interface{}{CathyID, GeorgeURNID}, 1)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND is_active = FALSE`, []interface{}{GeorgeID}, 1)
+}
+
+func TestMergePrecedence(t *testing.T) {
+	older := &Contact{}
+	newer := &Contact{}
+	newer.modifiedOn = older.modifiedOn.Add(1)
+
+	assert.False(t, MergePrecedence("").keepLoser(older, newer))
+	assert.False(t, MergeKeepSurvivor.keepLoser(older, newer))
+	assert.True(t, MergeKeepLoser.keepLoser(newer, older))
+	assert.True(t, MergeKeepNewest.keepLoser(older, newer))
+	assert.False(t, MergeKeepNewest.keepLoser(newer, older))
+}
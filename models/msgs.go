@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
@@ -448,6 +449,42 @@ func UpdateMessage(ctx context.Context, tx Queryer, msgID flows.MsgID, status Ms
 	return nil
 }
 
+// SetMsgMetadata sets the passed in key of the metadata of the passed in message to the passed in value
+func SetMsgMetadata(ctx context.Context, tx Queryer, msgID flows.MsgID, key string, value interface{}) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling metadata for msg: %d", msgID)
+	}
+
+	_, err = tx.ExecContext(ctx, setMsgMetadataSQL, msgID, key, string(valueJSON))
+	if err != nil {
+		return errors.Wrapf(err, "error updating metadata for msg: %d", msgID)
+	}
+	return nil
+}
+
+const setMsgMetadataSQL = `
+UPDATE
+	msgs_msg
+SET
+	metadata = JSONB_SET(COALESCE(metadata, '{}')::jsonb, ARRAY[$2::text], $3::jsonb)::text
+WHERE
+	id = $1
+`
+
+// GetMsgStatus returns the current status of the passed in message, or an empty status if it doesn't exist
+func GetMsgStatus(ctx context.Context, db Queryer, msgID flows.MsgID) (MsgStatus, error) {
+	var status MsgStatus
+	err := db.GetContext(ctx, &status, `SELECT status FROM msgs_msg WHERE id = $1`, msgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error loading status for msg: %d", msgID)
+	}
+	return status, nil
+}
+
 // MarkMessagesPending marks the passed in messages as pending
 func MarkMessagesPending(ctx context.Context, tx *sqlx.Tx, msgs []*Msg) error {
 	return updateMessageStatus(ctx, tx, msgs, MsgStatusPending)
@@ -806,20 +843,92 @@ func CreateBroadcastMessages(ctx context.Context, db Queryer, rp *redis.Pool, or
 	return msgs, nil
 }
 
-// MarkBroadcastSent marks the passed in broadcast as sent
+// MarkBroadcastSent marks the passed in broadcast as sent (unless it has been cancelled)
 func MarkBroadcastSent(ctx context.Context, db *sqlx.DB, id BroadcastID) error {
 	// noop if it is a nil id
 	if id == NilBroadcastID {
 		return nil
 	}
 
-	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1`, id)
+	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1 AND status != 'F'`, id)
 	if err != nil {
 		return errors.Wrapf(err, "error setting broadcast with id %d as sent", id)
 	}
 	return nil
 }
 
+// cancelledBroadcastKey is the redis key we set when a broadcast is cancelled, checked before sending each batch
+const cancelledBroadcastKey = "cancelled_broadcast:%d"
+
+// CancelBroadcast marks the passed in broadcast as cancelled so that none of its remaining batches are sent. There's
+// no cancelled status for broadcasts so it is marked as failed. It returns whether the broadcast was found and cancelled.
+func CancelBroadcast(ctx context.Context, db *sqlx.DB, rc redis.Conn, orgID OrgID, id BroadcastID) (bool, error) {
+	return cancelWithKey(ctx, db, rc, cancelBroadcastSQL, orgID, int64(id), fmt.Sprintf(cancelledBroadcastKey, id))
+}
+
+const cancelBroadcastSQL = `
+UPDATE 
+	msgs_broadcast 
+SET 
+	status = 'F', 
+	modified_on = NOW() 
+WHERE 
+	id = $1 AND 
+	org_id = $2 AND 
+	status NOT IN ('S', 'F')
+`
+
+// cancelWithKey runs the passed in SQL to cancel a start or broadcast, and if it was found, sets the passed in redis
+// key which batches check before doing any work. The key is set before the status change is committed, so that if
+// setting it fails, the start or broadcast can still be cancelled again.
+func cancelWithKey(ctx context.Context, db *sqlx.DB, rc redis.Conn, sql string, orgID OrgID, id int64, key string) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrapf(err, "error starting transaction to cancel: %d", id)
+	}
+
+	result, err := tx.ExecContext(ctx, sql, id, orgID)
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrapf(err, "error cancelling: %d", id)
+	}
+
+	cancelled, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrapf(err, "error cancelling: %d", id)
+	}
+	if cancelled == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	_, err = rc.Do("set", key, "true", "EX", cancelledExpiration)
+	if err != nil {
+		tx.Rollback()
+		return false, errors.Wrapf(err, "error marking: %d as cancelled", id)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.Wrapf(err, "error committing cancellation of: %d", id)
+	}
+	return true, nil
+}
+
+// IsBroadcastCancelled returns whether the passed in broadcast has been cancelled
+func IsBroadcastCancelled(rc redis.Conn, id BroadcastID) (bool, error) {
+	if id == NilBroadcastID {
+		return false, nil
+	}
+
+	cancelled, err := redis.Bool(rc.Do("exists", fmt.Sprintf(cancelledBroadcastKey, id)))
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking whether broadcast: %d is cancelled", id)
+	}
+	return cancelled, nil
+}
+
 // NilID implementations
 
 // MarshalJSON marshals into JSON. 0 values will become null
@@ -105,3 +105,46 @@ func TestNormalizeAttachment(t *testing.T) {
 		assert.Equal(t, tc.normalized, string(NormalizeAttachment(utils.Attachment(tc.raw))))
 	}
 }
+
+func TestCancelBroadcast(t *testing.T) {
+	ctx, db, rp := testsuite.Reset()
+	rc := rp.Get()
+	defer rc.Close()
+
+	var broadcastID BroadcastID
+	err := db.Get(&broadcastID,
+		`INSERT INTO msgs_broadcast(status, text, base_language, is_active, created_on, modified_on, purged, send_all, created_by_id, modified_by_id, org_id)
+		 VALUES('Q', '"base"=>"hi"'::hstore, 'base', TRUE, NOW(), NOW(), FALSE, FALSE, 1, 1, $1) RETURNING id`, Org1)
+	assert.NoError(t, err)
+
+	cancelled, err := IsBroadcastCancelled(rc, broadcastID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+
+	// can't cancel a broadcast from another org
+	cancelled, err = CancelBroadcast(ctx, db, rc, Org2, broadcastID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+
+	cancelled, err = IsBroadcastCancelled(rc, broadcastID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+
+	cancelled, err = CancelBroadcast(ctx, db, rc, Org1, broadcastID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	cancelled, err = IsBroadcastCancelled(rc, broadcastID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	// marking it sent doesn't change its status
+	err = MarkBroadcastSent(ctx, db, broadcastID)
+	assert.NoError(t, err)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_broadcast WHERE id = $1 AND status = 'F'`, []interface{}{broadcastID}, 1)
+
+	// and it can't be cancelled twice
+	cancelled, err = CancelBroadcast(ctx, db, rc, Org1, broadcastID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/utils"
@@ -18,18 +20,26 @@ type StartID null.Int
 // NilStartID is our constant for a nil start id
 var NilStartID = StartID(0)
 
-// MarkStartComplete sets the status for the passed in flow start
+const (
+	// cancelledStartKey is the redis key we set when a start is cancelled, checked before starting each batch
+	cancelledStartKey = "cancelled_start:%d"
+
+	// cancelledExpiration is how long we remember cancelled starts and broadcasts, no batches should be queued longer
+	cancelledExpiration = 60 * 60 * 24 * 7
+)
+
+// MarkStartComplete sets the status for the passed in flow start (unless it has been cancelled)
 func MarkStartComplete(ctx context.Context, db *sqlx.DB, startID StartID) error {
-	_, err := db.Exec("UPDATE flows_flowstart SET status = 'C' WHERE id = $1", startID)
+	_, err := db.Exec("UPDATE flows_flowstart SET status = 'C' WHERE id = $1 AND status != 'I'", startID)
 	if err != nil {
 		return errors.Wrapf(err, "error setting start as complete")
 	}
 	return nil
 }
 
-// MarkStartStarted sets the status for the passed in flow start to S and updates the contact count on it
+// MarkStartStarted sets the status for the passed in flow start to S and updates the contact count on it (unless it has been cancelled)
 func MarkStartStarted(ctx context.Context, db *sqlx.DB, startID StartID, contactCount int) error {
-	_, err := db.Exec("UPDATE flows_flowstart SET status = 'S', contact_count = $2 WHERE id = $1", startID, contactCount)
+	_, err := db.Exec("UPDATE flows_flowstart SET status = 'S', contact_count = $2 WHERE id = $1 AND status != 'I'", startID, contactCount)
 	if err != nil {
 		return errors.Wrapf(err, "error setting start as started")
 	}
@@ -37,6 +47,40 @@ func MarkStartStarted(ctx context.Context, db *sqlx.DB, startID StartID, contact
 
 }
 
+// CancelFlowStart marks the passed in pending or started flow start as cancelled (status I) so that none of its
+// remaining batches are started. It returns whether the start was found and cancelled.
+func CancelFlowStart(ctx context.Context, db *sqlx.DB, rc redis.Conn, orgID OrgID, startID StartID) (bool, error) {
+	return cancelWithKey(ctx, db, rc, cancelFlowStartSQL, orgID, int64(startID), fmt.Sprintf(cancelledStartKey, startID))
+}
+
+const cancelFlowStartSQL = `
+UPDATE 
+	flows_flowstart s
+SET 
+	status = 'I', 
+	modified_on = NOW() 
+FROM 
+	flows_flow f 
+WHERE 
+	s.id = $1 AND 
+	s.flow_id = f.id AND 
+	f.org_id = $2 AND 
+	s.status IN ('P', 'S')
+`
+
+// IsFlowStartCancelled returns whether the passed in flow start has been cancelled
+func IsFlowStartCancelled(rc redis.Conn, startID StartID) (bool, error) {
+	if startID == NilStartID {
+		return false, nil
+	}
+
+	cancelled, err := redis.Bool(rc.Do("exists", fmt.Sprintf(cancelledStartKey, startID)))
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking whether start: %d is cancelled", startID)
+	}
+	return cancelled, nil
+}
+
 // FlowStartBatch represents a single flow batch that needs to be started
 type FlowStartBatch struct {
 	b struct {
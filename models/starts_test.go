@@ -0,0 +1,48 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelFlowStart(t *testing.T) {
+	ctx, db, rp := testsuite.Reset()
+	rc := rp.Get()
+	defer rc.Close()
+
+	var startID StartID
+	err := db.Get(&startID,
+		`INSERT INTO flows_flowstart(is_active, created_on, modified_on, uuid, restart_participants, include_active, contact_count, status, flow_id, created_by_id, modified_by_id)
+		 VALUES(TRUE, NOW(), NOW(), $1, TRUE, TRUE, 2, 'P', $2, 1, 1) RETURNING id`, utils.NewUUID(), SingleMessageFlowID)
+	assert.NoError(t, err)
+
+	cancelled, err := IsFlowStartCancelled(rc, startID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+
+	// can't cancel a start from another org
+	cancelled, err = CancelFlowStart(ctx, db, rc, Org2, startID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+
+	cancelled, err = CancelFlowStart(ctx, db, rc, Org1, startID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	cancelled, err = IsFlowStartCancelled(rc, startID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	// completing it doesn't change its status
+	err = MarkStartComplete(ctx, db, startID)
+	assert.NoError(t, err)
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM flows_flowstart WHERE id = $1 AND status = 'I'`, []interface{}{startID}, 1)
+
+	// and it can't be cancelled twice
+	cancelled, err = CancelFlowStart(ctx, db, rc, Org1, startID)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+}
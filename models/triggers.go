@@ -2,8 +2,11 @@ package models
 
 import (
 	"context"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/flows"
@@ -11,6 +14,11 @@ import (
 	"github.com/nyaruka/goflow/utils"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
 type TriggerType string
@@ -27,13 +35,35 @@ const (
 	ReferralTriggerType        = TriggerType("R")
 	CallTriggerType            = TriggerType("V")
 
-	MatchFirst = "F"
-	MatchOnly  = "O"
+	MatchFirst  = "F"
+	MatchOnly   = "O"
+	MatchPhrase = "P"
+	MatchRegex  = "R"
+
+	// KeywordMatchTypePhrase is how we describe to the engine a trigger matched by a phrase in the message
+	KeywordMatchTypePhrase = triggers.KeywordMatchType("phrase")
+
+	// KeywordMatchTypeRegex is how we describe to the engine a trigger matched by a regular expression
+	KeywordMatchTypeRegex = triggers.KeywordMatchType("regex")
 
 	NilTriggerID = TriggerID(0)
 )
 
-// Trigger represents a trigger in an organization
+// TriggerWindowsConfig is the org config key of the windows which triggers are restricted to, by trigger id, e.g.
+//
+//   {
+//     "123": {"days": [1, 2, 3, 4, 5], "start": "08:00", "end": "17:00"}
+//   }
+//
+const TriggerWindowsConfig = "trigger_windows"
+
+// Trigger represents a trigger in an organization. Keyword triggers can have several comma separated keywords, such
+// as translations, any of which can match, except for regex triggers whose keyword is a single regular expression.
+// Regular expressions are matched ignoring case against message text with its accents stripped, so should be written
+// without accents.
+//
+// Any trigger can be restricted to a window of days of the week and times of day in the org's timezone, outside of
+// which it is ignored. Windows are kept in the org config, see TriggerWindowsConfig.
 type Trigger struct {
 	t struct {
 		ID          TriggerID   `json:"id"`
@@ -44,7 +74,18 @@ type Trigger struct {
 		ChannelID   ChannelID   `json:"channel_id"`
 		ReferrerID  string      `json:"referrer_id"`
 		GroupIDs    []GroupID   `json:"group_ids"`
+		Window      struct {
+			Days  []int  `json:"days"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"window"`
 	}
+
+	window *Window
+
+	prepare  sync.Once
+	keywords map[string][]string
+	regex    *regexp.Regexp
 }
 
 func (t *Trigger) ID() TriggerID            { return t.t.ID }
@@ -55,24 +96,153 @@ func (t *Trigger) MatchType() MatchType     { return t.t.MatchType }
 func (t *Trigger) ChannelID() ChannelID     { return t.t.ChannelID }
 func (t *Trigger) ReferrerID() string       { return t.t.ReferrerID }
 func (t *Trigger) GroupIDs() []GroupID      { return t.t.GroupIDs }
+func (t *Trigger) ActiveDays() []int        { return t.t.Window.Days }
 func (t *Trigger) KeywordMatchType() triggers.KeywordMatchType {
-	if t.t.MatchType == MatchFirst {
+	switch t.t.MatchType {
+	case MatchFirst:
 		return triggers.KeywordMatchTypeFirstWord
+	case MatchPhrase:
+		return KeywordMatchTypePhrase
+	case MatchRegex:
+		return KeywordMatchTypeRegex
 	}
 	return triggers.KeywordMatchTypeOnlyWord
 }
 
-// Match returns the match for this trigger, if any
-func (t *Trigger) Match() *triggers.KeywordMatch {
-	if t.Keyword() != "" {
-		return &triggers.KeywordMatch{
-			Type:    t.KeywordMatchType(),
-			Keyword: t.Keyword(),
-		}
+// HasWindow returns whether this trigger is restricted to certain days or times
+func (t *Trigger) HasWindow() bool {
+	return t.window != nil && !t.window.IsEmpty()
+}
+
+// parseWindow parses the window this trigger is restricted to
+func (t *Trigger) parseWindow() error {
+	window, err := NewWindow(t.t.Window.Days, t.t.Window.Start, t.t.Window.End)
+	if err != nil {
+		return errors.Wrapf(err, "invalid window for trigger: %d", t.ID())
 	}
+	t.window = window
 	return nil
 }
 
+// InWindow returns whether this trigger is active at the passed in time in the passed in timezone
+func (t *Trigger) InWindow(now time.Time, tz *time.Location) bool {
+	return !t.HasWindow() || t.window.Contains(now, tz)
+}
+
+// Keywords returns the keywords of this trigger, any of which can match
+func (t *Trigger) Keywords() []string {
+	if t.t.MatchType == MatchRegex {
+		return []string{t.t.Keyword}
+	}
+
+	keywords := make([]string, 0, 1)
+	for _, k := range strings.Split(t.t.Keyword, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
+
+// prepareKeywords normalizes our keywords, or compiles our regular expression, for matching against messages in
+// the passed in language. Triggers are loaded with their org's assets so this only needs to happen once.
+func (t *Trigger) prepareKeywords(lang utils.Language) {
+	t.prepare.Do(func() {
+		if t.t.MatchType == MatchRegex {
+			regex, err := regexp.Compile("(?i)" + t.t.Keyword)
+			if err != nil {
+				logrus.WithError(err).WithField("trigger_id", t.ID()).Error("invalid regex for trigger")
+				return
+			}
+			t.regex = regex
+			return
+		}
+
+		t.keywords = make(map[string][]string)
+		for _, k := range t.Keywords() {
+			words := utils.TokenizeString(normalizeKeyword(lang, k))
+			if len(words) > 0 {
+				t.keywords[k] = words
+			}
+		}
+	})
+}
+
+// matchesKeywords returns which of this keyword trigger's keywords matches the passed in normalized message text and
+// words, if any
+func (t *Trigger) matchesKeywords(lang utils.Language, text string, words []string) (string, bool) {
+	t.prepareKeywords(lang)
+
+	if t.t.MatchType == MatchRegex {
+		return t.t.Keyword, t.regex != nil && t.regex.MatchString(text)
+	}
+
+	for _, k := range t.Keywords() {
+		keyword, found := t.keywords[k]
+		if !found {
+			continue
+		}
+
+		switch t.t.MatchType {
+		case MatchFirst:
+			if len(words) >= len(keyword) && wordsEqual(words[:len(keyword)], keyword) {
+				return k, true
+			}
+		case MatchOnly:
+			if wordsEqual(words, keyword) {
+				return k, true
+			}
+		case MatchPhrase:
+			for i := 0; i+len(keyword) <= len(words); i++ {
+				if wordsEqual(words[i:i+len(keyword)], keyword) {
+					return k, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// wordsEqual returns whether the two passed in lists of words are the same
+func wordsEqual(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// languages in which accents distinguish words, so aren't ignored when matching keywords
+var accentSensitiveLanguages = map[utils.Language]bool{
+	"vie": true,
+}
+
+// normalizeKeyword lower cases the passed in text according to the passed in language and, unless accents
+// distinguish words in that language, strips them so that keywords match regardless of accents
+func normalizeKeyword(lang utils.Language, text string) string {
+	tag := language.Und
+	if lang != utils.NilLanguage {
+		if parsed, err := language.Parse(string(lang)); err == nil {
+			tag = parsed
+		}
+	}
+
+	text = cases.Lower(tag).String(text)
+
+	if !accentSensitiveLanguages[lang] {
+		stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+		if err == nil {
+			text = stripped
+		}
+	}
+	return text
+}
+
 func loadTriggers(ctx context.Context, db *sqlx.DB, orgID OrgID) ([]*Trigger, error) {
 	start := time.Now()
 
@@ -89,6 +259,10 @@ func loadTriggers(ctx context.Context, db *sqlx.DB, orgID OrgID) ([]*Trigger, er
 		if err != nil {
 			return nil, errors.Wrap(err, "error scanning label row")
 		}
+		err = trigger.parseWindow()
+		if err != nil {
+			return nil, err
+		}
 		triggers = append(triggers, trigger)
 	}
 
@@ -97,10 +271,29 @@ func loadTriggers(ctx context.Context, db *sqlx.DB, orgID OrgID) ([]*Trigger, er
 	return triggers, nil
 }
 
+// activeTriggers returns those of the passed in org's triggers which are active now in the org's timezone. Those
+// restricted to a window are returned before those which aren't, so that they take precedence over otherwise
+// equivalent triggers.
+func activeTriggers(org *OrgAssets) []*Trigger {
+	now := utils.Now()
+	tz := org.Env().Timezone()
+
+	windowed := make([]*Trigger, 0, 5)
+	unrestricted := make([]*Trigger, 0, len(org.Triggers()))
+	for _, t := range org.Triggers() {
+		if !t.HasWindow() {
+			unrestricted = append(unrestricted, t)
+		} else if t.InWindow(now, tz) {
+			windowed = append(windowed, t)
+		}
+	}
+	return append(windowed, unrestricted...)
+}
+
 // FindMatchingNewConversationTrigger returns the matching trigger for the passed in trigger type
 func FindMatchingNewConversationTrigger(org *OrgAssets, channel *Channel) *Trigger {
 	var match *Trigger
-	for _, t := range org.Triggers() {
+	for _, t := range activeTriggers(org) {
 		if t.TriggerType() == NewConversationTriggerType {
 			// exact match? return right away
 			if t.ChannelID() == channel.ID() {
@@ -119,7 +312,7 @@ func FindMatchingNewConversationTrigger(org *OrgAssets, channel *Channel) *Trigg
 
 // FindMatchingMissedCallTrigger finds any trigger set up for incoming calls (these would be IVR flows)
 func FindMatchingMissedCallTrigger(org *OrgAssets) *Trigger {
-	for _, t := range org.Triggers() {
+	for _, t := range activeTriggers(org) {
 		if t.TriggerType() == MissedCallTriggerType {
 			return t
 		}
@@ -138,7 +331,7 @@ func FindMatchingMOCallTrigger(org *OrgAssets, contact *Contact) *Trigger {
 	}
 
 	var match *Trigger
-	for _, t := range org.Triggers() {
+	for _, t := range activeTriggers(org) {
 		if t.TriggerType() == CallTriggerType {
 			// this trigger has no groups, it's a match!
 			if len(t.GroupIDs()) == 0 {
@@ -165,7 +358,7 @@ func FindMatchingMOCallTrigger(org *OrgAssets, contact *Contact) *Trigger {
 // Matches are based on referrer_id first (if present), then channel, then any referrer trigger
 func FindMatchingReferralTrigger(org *OrgAssets, channel *Channel, referrerID string) *Trigger {
 	var match *Trigger
-	for _, t := range org.Triggers() {
+	for _, t := range activeTriggers(org) {
 		if t.TriggerType() == ReferralTriggerType {
 			// matches referrer id? that takes top precedence, return right away
 			if referrerID != "" && referrerID == t.ReferrerID() && (t.ChannelID() == NilChannelID || t.ChannelID() == channel.ID()) {
@@ -188,51 +381,71 @@ func FindMatchingReferralTrigger(org *OrgAssets, channel *Channel, referrerID st
 	return match
 }
 
-// FindMatchingMsgTrigger returns the matching trigger (if any) for the passed in text and channel id
+// keywordPrecedence is the order in which keyword triggers of each match type take precedence when several match
+var keywordPrecedence = map[MatchType]int{
+	MatchOnly:   0,
+	MatchFirst:  1,
+	MatchPhrase: 2,
+	MatchRegex:  3,
+}
+
+// FindMatchingMsgTrigger returns the matching trigger (if any) for the passed in text and contact, and for keyword
+// triggers the match for whichever of its keywords matched. Keyword triggers for the contact's groups take precedence
+// over those without groups, and then triggers matching the only word take precedence over those matching the first
+// word, then a phrase, then a regex. If no keyword trigger matches we fall back to any catch all trigger for the
+// contact's groups, then any catch all trigger. Triggers restricted to a
+// window are only considered within it, where they take precedence over otherwise equivalent triggers.
 // TODO: with a different structure this could probably be a lot faster.. IE, we could have a map
 // of list of triggers by keyword that is built when we load the triggers, then just evaluate against that.
-func FindMatchingMsgTrigger(org *OrgAssets, contact *flows.Contact, text string) *Trigger {
+func FindMatchingMsgTrigger(org *OrgAssets, contact *flows.Contact, text string) (*Trigger, *triggers.KeywordMatch) {
 	// build a set of the groups this contact is in
 	groupIDs := make(map[GroupID]bool, 10)
 	for _, g := range contact.Groups().All() {
 		groupIDs[g.Asset().(*Group).ID()] = true
 	}
 
-	// determine our message keyword
+	// normalize our message text and split it into words
+	lang := org.Env().DefaultLanguage()
+	text = normalizeKeyword(lang, text)
 	words := utils.TokenizeString(text)
-	keyword := ""
-	only := false
-	if len(words) > 0 {
-		// our keyword is our first word
-		keyword = strings.ToLower(words[0])
-		only = len(words) == 1
+
+	inGroups := func(t *Trigger) bool {
+		for _, g := range t.GroupIDs() {
+			if groupIDs[g] {
+				return true
+			}
+		}
+		return false
 	}
 
 	var match, catchAll, groupCatchAll *Trigger
-	for _, t := range org.Triggers() {
-		if t.TriggerType() == KeywordTriggerType {
-			// does this match based on the rules of the trigger?
-			matched := (t.Keyword() == keyword && (t.MatchType() == MatchFirst || (t.MatchType() == MatchOnly && only)))
+	var matchKeyword string
+	matchRank := 0
 
-			// no match? move on
-			if !matched {
+	for _, t := range activeTriggers(org) {
+		if t.TriggerType() == KeywordTriggerType {
+			precedence, known := keywordPrecedence[t.MatchType()]
+			if !known {
 				continue
 			}
 
-			// this trigger has no groups, it's a match!
+			// triggers without groups rank below those for any of our groups
+			rank := precedence
 			if len(t.GroupIDs()) == 0 {
-				if match == nil {
-					match = t
-				}
+				rank += len(keywordPrecedence)
+			} else if !inGroups(t) {
 				continue
 			}
 
-			// test whether we are part of this trigger's group
-			for _, g := range t.GroupIDs() {
-				if groupIDs[g] {
-					// group keyword matches always take precedence, can return right away
-					return t
-				}
+			// can't beat our current match? move on
+			if match != nil && rank >= matchRank {
+				continue
+			}
+
+			if keyword, matches := t.matchesKeywords(lang, text, words); matches {
+				match = t
+				matchKeyword = keyword
+				matchRank = rank
 			}
 		} else if t.TriggerType() == CatchallTriggerType {
 			// if this catch all is on no groups, save it as our catch all
@@ -244,29 +457,24 @@ func FindMatchingMsgTrigger(org *OrgAssets, contact *flows.Contact, text string)
 			}
 
 			// otherwise see if this catchall matches our group
-			if groupCatchAll == nil {
-				for _, g := range t.GroupIDs() {
-					if groupIDs[g] {
-						groupCatchAll = t
-						break
-					}
-				}
+			if groupCatchAll == nil && inGroups(t) {
+				groupCatchAll = t
 			}
 		}
 	}
 
-	// have a normal match? return that
+	// have a keyword match? return that
 	if match != nil {
-		return match
+		return match, &triggers.KeywordMatch{Type: match.KeywordMatchType(), Keyword: matchKeyword}
 	}
 
 	// otherwise return our group catch all if we found one
 	if groupCatchAll != nil {
-		return groupCatchAll
+		return groupCatchAll, nil
 	}
 
 	// or our global catchall
-	return catchAll
+	return catchAll, nil
 }
 
 const selectTriggersSQL = `
@@ -278,15 +486,17 @@ SELECT ROW_TO_JSON(r) FROM (SELECT
 	t.match_type as match_type,
 	t.channel_id as channel_id,
 	COALESCE(t.referrer_id, '') as referrer_id,
-	ARRAY_REMOVE(ARRAY_AGG(g.contactgroup_id), NULL) as group_ids
+	ARRAY_REMOVE(ARRAY_AGG(g.contactgroup_id), NULL) as group_ids,
+	COALESCE(o.config::json->'trigger_windows'->(t.id::text), '{}') as window
 FROM 
 	triggers_trigger t
+	JOIN orgs_org o ON t.org_id = o.id
 	LEFT OUTER JOIN triggers_trigger_groups g ON t.id = g.trigger_id
 WHERE 
 	t.org_id = $1 AND 
 	t.is_active = TRUE AND
 	t.is_archived = FALSE
 GROUP BY 
-	t.id
+	t.id, o.id
 ) r;
 `
@@ -2,10 +2,13 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/engine"
+	"github.com/nyaruka/goflow/flows/triggers"
+	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/stretchr/testify/assert"
 )
@@ -77,6 +80,19 @@ func TestTriggers(t *testing.T) {
 	farmersID := insertTrigger(t, db, true, SingleMessageFlowID, KeywordTriggerType, "resist", MatchOnly, []GroupID{DoctorsGroupID}, "", NilChannelID)
 	farmersAllID := insertTrigger(t, db, true, SingleMessageFlowID, CatchallTriggerType, "", MatchOnly, []GroupID{DoctorsGroupID}, "", NilChannelID)
 	othersAllID := insertTrigger(t, db, true, SingleMessageFlowID, CatchallTriggerType, "", MatchOnly, nil, "", NilChannelID)
+	helpID := insertTrigger(t, db, true, FavoritesFlowID, KeywordTriggerType, "need help,ayuda", MatchPhrase, nil, "", NilChannelID)
+	orderID := insertTrigger(t, db, true, FavoritesFlowID, KeywordTriggerType, `^order\s*\d+$`, MatchRegex, nil, "", NilChannelID)
+	cafeID := insertTrigger(t, db, true, FavoritesFlowID, KeywordTriggerType, "café,coffee", MatchOnly, nil, "", NilChannelID)
+	helpMeID := insertTrigger(t, db, true, FavoritesFlowID, KeywordTriggerType, "help", MatchFirst, nil, "", NilChannelID)
+	insertTrigger(t, db, true, FavoritesFlowID, KeywordTriggerType, "office", MatchOnly, nil, "", NilChannelID)
+	officeID := insertTrigger(t, db, true, SingleMessageFlowID, KeywordTriggerType, "office", MatchOnly, nil, "", NilChannelID)
+	closedID := insertTrigger(t, db, true, SingleMessageFlowID, KeywordTriggerType, "closed", MatchOnly, nil, "", NilChannelID)
+
+	// restrict our office trigger to a window which is always open, and our closed trigger to one which never is
+	db.MustExec(`UPDATE orgs_org SET config = JSON_BUILD_OBJECT('trigger_windows', JSON_BUILD_OBJECT(
+		$2::text, '{"days": [0, 1, 2, 3, 4, 5, 6], "start": "00:00"}'::json,
+		$3::text, '{"start": "10:00", "end": "10:00"}'::json
+	))::text WHERE id = $1`, Org1, officeID, closedID)
 
 	FlushCache()
 
@@ -109,14 +125,156 @@ func TestTriggers(t *testing.T) {
 		{"other", cathy, farmersAllID},
 		{"other", greg, othersAllID},
 		{"", greg, othersAllID},
+		{"I need help please", greg, helpID},
+		{"¡Ayuda!", greg, helpID},
+		{"Order 1234", greg, orderID},
+		{"my order 1234", greg, othersAllID},
+		{"Cafe", greg, cafeID},
+		{"COFFEE", greg, cafeID},
+		{"help I need help", greg, helpMeID},
+		{"resist need help", cathy, farmersAllID},
+		{"office", greg, officeID},
+		{"closed", greg, othersAllID},
 	}
 
 	for i, tc := range tcs {
-		trigger := FindMatchingMsgTrigger(org, tc.Contact, tc.Text)
+		trigger, _ := FindMatchingMsgTrigger(org, tc.Contact, tc.Text)
 		if trigger == nil {
 			assert.Equal(t, tc.TriggerID, TriggerID(0), "%d: did not get back expected trigger", i)
 		} else {
 			assert.Equal(t, tc.TriggerID, trigger.ID(), "%d: did not get back expected trigger", i)
 		}
 	}
+
+	// the match is for whichever of the trigger's keywords matched
+	matchTcs := []struct {
+		Text      string
+		Keyword   string
+		MatchType triggers.KeywordMatchType
+	}{
+		{"join now", "join", triggers.KeywordMatchTypeFirstWord},
+		{"¡Ayuda!", "ayuda", KeywordMatchTypePhrase},
+		{"Cafe", "café", triggers.KeywordMatchTypeOnlyWord},
+		{"COFFEE", "coffee", triggers.KeywordMatchTypeOnlyWord},
+		{"Order 1234", `^order\s*\d+$`, KeywordMatchTypeRegex},
+	}
+
+	for i, tc := range matchTcs {
+		_, match := FindMatchingMsgTrigger(org, greg, tc.Text)
+		if assert.NotNil(t, match, "%d: expected keyword match", i) {
+			assert.Equal(t, tc.Keyword, match.Keyword, "%d: unexpected keyword", i)
+			assert.Equal(t, tc.MatchType, match.Type, "%d: unexpected match type", i)
+		}
+	}
+
+	// catch all triggers have no keyword match
+	_, match := FindMatchingMsgTrigger(org, greg, "other")
+	assert.Nil(t, match)
+}
+
+func TestTriggerWindows(t *testing.T) {
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+
+	newTrigger := func(days []int, start string, end string) *Trigger {
+		trigger := &Trigger{}
+		trigger.t.Window.Days = days
+		trigger.t.Window.Start = start
+		trigger.t.Window.End = end
+		assert.NoError(t, trigger.parseWindow())
+		return trigger
+	}
+
+	weekdays := []int{1, 2, 3, 4, 5}
+
+	// Monday 10:30 and Saturday 10:30 in Los Angeles
+	monday := time.Date(2019, 2, 4, 18, 30, 0, 0, time.UTC)
+	saturday := time.Date(2019, 2, 9, 18, 30, 0, 0, time.UTC)
+
+	tcs := []struct {
+		Trigger   *Trigger
+		Now       time.Time
+		HasWindow bool
+		InWindow  bool
+	}{
+		{newTrigger(nil, "", ""), monday, false, true},
+		{newTrigger(weekdays, "", ""), monday, true, true},
+		{newTrigger(weekdays, "", ""), saturday, true, false},
+		{newTrigger(weekdays, "09:00", "17:00"), monday, true, true},
+		{newTrigger(weekdays, "09:00", "17:00"), monday.Add(-2 * time.Hour), true, false},
+		{newTrigger(weekdays, "09:00", "17:00"), monday.Add(6*time.Hour + 30*time.Minute), true, false},
+		{newTrigger(nil, "17:00", "09:00"), monday, true, false},
+		{newTrigger(nil, "17:00", "09:00"), monday.Add(8 * time.Hour), true, true},
+		{newTrigger(nil, "17:00", "09:00"), monday.Add(-3 * time.Hour), true, true},
+		{newTrigger(nil, "10:00", ""), monday, true, true},
+		{newTrigger(nil, "", "10:00"), monday, true, false},
+	}
+
+	for i, tc := range tcs {
+		assert.Equal(t, tc.HasWindow, tc.Trigger.HasWindow(), "%d: unexpected has window", i)
+		assert.Equal(t, tc.InWindow, tc.Trigger.InWindow(tc.Now, tz), "%d: unexpected in window", i)
+	}
+
+	trigger := &Trigger{}
+	trigger.t.Window.Start = "25:00"
+	assert.Error(t, trigger.parseWindow())
+}
+
+func TestNormalizeKeyword(t *testing.T) {
+	tcs := []struct {
+		Language   utils.Language
+		Text       string
+		Normalized string
+	}{
+		{utils.NilLanguage, "JOIN", "join"},
+		{"eng", "Café", "cafe"},
+		{"spa", "¡Sí, AYUDA!", "¡si, ayuda!"},
+		{"fra", "Élève", "eleve"},
+		{"tur", "İPTAL", "iptal"},
+		{"vie", "Đăng Ký", "đăng ký"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.Normalized, normalizeKeyword(tc.Language, tc.Text), "unexpected normalization of: %s", tc.Text)
+	}
+}
+
+func TestMatchesKeywords(t *testing.T) {
+	newTrigger := func(keyword string, matchType MatchType) *Trigger {
+		trigger := &Trigger{}
+		trigger.t.TriggerType = KeywordTriggerType
+		trigger.t.Keyword = keyword
+		trigger.t.MatchType = matchType
+		return trigger
+	}
+
+	tcs := []struct {
+		Trigger *Trigger
+		Text    string
+		Matches bool
+		Keyword string
+	}{
+		{newTrigger("join", MatchFirst), "join now", true, "join"},
+		{newTrigger("join", MatchFirst), "please join", false, ""},
+		{newTrigger("join, unirse", MatchFirst), "Unirse ahora", true, "unirse"},
+		{newTrigger("join", MatchOnly), "join", true, "join"},
+		{newTrigger("join", MatchOnly), "join now", false, ""},
+		{newTrigger("sign up", MatchOnly), "sign up", true, "sign up"},
+		{newTrigger("sign up", MatchFirst), "sign up now", true, "sign up"},
+		{newTrigger("sign up", MatchPhrase), "can I sign up?", true, "sign up"},
+		{newTrigger("sign up", MatchPhrase), "sign me up", false, ""},
+		{newTrigger("hello, inscripción", MatchPhrase), "quiero una INSCRIPCION", true, "inscripción"},
+		{newTrigger(`^\d{4}$`, MatchRegex), "1234", true, `^\d{4}$`},
+		{newTrigger(`^\d{4}$`, MatchRegex), "12345", false, `^\d{4}$`},
+		{newTrigger(`^\D+\S\W$`, MatchRegex), "ab!", true, `^\D+\S\W$`},
+		{newTrigger(`^\D+$`, MatchRegex), "1234", false, `^\D+$`},
+		{newTrigger(`(stop|arret)`, MatchRegex), "ARRÊT", true, `(stop|arret)`},
+		{newTrigger(`(unclosed`, MatchRegex), "unclosed", false, `(unclosed`},
+	}
+
+	for _, tc := range tcs {
+		text := normalizeKeyword("fra", tc.Text)
+		keyword, matches := tc.Trigger.matchesKeywords("fra", text, utils.TokenizeString(text))
+		assert.Equal(t, tc.Matches, matches, "unexpected match of %s trigger %s against: %s", tc.Trigger.MatchType(), tc.Trigger.Keyword(), tc.Text)
+		assert.Equal(t, tc.Keyword, keyword, "unexpected keyword of %s trigger %s against: %s", tc.Trigger.MatchType(), tc.Trigger.Keyword(), tc.Text)
+	}
 }
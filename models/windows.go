@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/pkg/errors"
+)
+
+// Window is a window of days of the week, where 0 is Sunday, and times of day. Windows where the start is after the
+// end, e.g. 18:00 to 08:00, run overnight. No days means any day, and no start or end means from or until midnight.
+type Window struct {
+	days  []int
+	start *utils.TimeOfDay
+	end   *utils.TimeOfDay
+}
+
+// NewWindow creates a new window from the passed in days and start and end times, formatted as HH:MM
+func NewWindow(days []int, start string, end string) (*Window, error) {
+	parse := func(value string) (*utils.TimeOfDay, error) {
+		if value == "" {
+			return nil, nil
+		}
+		tod, err := utils.ParseTimeOfDay("15:04", value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid window time: %s", value)
+		}
+		return &tod, nil
+	}
+
+	for _, d := range days {
+		if d < 0 || d > 6 {
+			return nil, errors.Errorf("invalid window day: %d", d)
+		}
+	}
+
+	w := &Window{days: days}
+	var err error
+	if w.start, err = parse(start); err != nil {
+		return nil, err
+	}
+	if w.end, err = parse(end); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// IsEmpty returns whether this window has no days or times, so is always open
+func (w *Window) IsEmpty() bool {
+	return len(w.days) == 0 && w.start == nil && w.end == nil
+}
+
+// Contains returns whether the passed in time is within this window in the passed in timezone. The early hours of an
+// overnight window belong to the day it started, so 02:00 on Tuesday is within a Monday 18:00 to 08:00 window.
+func (w *Window) Contains(now time.Time, tz *time.Location) bool {
+	local := now.In(tz)
+	tod := utils.ExtractTimeOfDay(local)
+	afterStart := w.start == nil || tod.Compare(*w.start) >= 0
+	beforeEnd := w.end == nil || tod.Compare(*w.end) < 0
+
+	// a window which runs overnight contains times after its start today or before its end following yesterday
+	if w.start != nil && w.end != nil && w.start.Compare(*w.end) > 0 {
+		return (afterStart && w.hasDay(local.Weekday())) || (beforeEnd && w.hasDay(local.AddDate(0, 0, -1).Weekday()))
+	}
+	return afterStart && beforeEnd && w.hasDay(local.Weekday())
+}
+
+// hasDay returns whether this window applies on the passed in day of the week
+func (w *Window) hasDay(day time.Weekday) bool {
+	if len(w.days) == 0 {
+		return true
+	}
+	for _, d := range w.days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowContains(t *testing.T) {
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+
+	// 2019-04-01 is a Monday
+	monday := func(hour, minute int) time.Time { return time.Date(2019, 4, 1, hour, minute, 0, 0, tz) }
+	tuesday := func(hour, minute int) time.Time { return time.Date(2019, 4, 2, hour, minute, 0, 0, tz) }
+
+	tcs := []struct {
+		Days     []int
+		Start    string
+		End      string
+		Time     time.Time
+		Contains bool
+	}{
+		{nil, "", "", monday(12, 0), true},
+		{nil, "09:00", "17:00", monday(9, 0), true},
+		{nil, "09:00", "17:00", monday(17, 0), false},
+		{[]int{1}, "09:00", "17:00", monday(12, 0), true},
+		{[]int{1}, "09:00", "17:00", tuesday(12, 0), false},
+		{[]int{1}, "", "", tuesday(12, 0), false},
+
+		// overnight windows
+		{nil, "18:00", "08:00", monday(20, 0), true},
+		{nil, "18:00", "08:00", monday(7, 0), true},
+		{nil, "18:00", "08:00", monday(12, 0), false},
+
+		// the early hours of an overnight window are on the day after it starts
+		{[]int{1}, "18:00", "08:00", monday(20, 0), true},
+		{[]int{1}, "18:00", "08:00", tuesday(2, 0), true},
+		{[]int{1}, "18:00", "08:00", tuesday(8, 0), false},
+		{[]int{1}, "18:00", "08:00", monday(2, 0), false},
+		{[]int{1}, "18:00", "08:00", tuesday(20, 0), false},
+	}
+
+	for i, tc := range tcs {
+		window, err := NewWindow(tc.Days, tc.Start, tc.End)
+		assert.NoError(t, err, "%d: error creating window", i)
+		assert.Equal(t, tc.Contains, window.Contains(tc.Time, tz), "%d: contains mismatch for %s", i, tc.Time)
+	}
+}
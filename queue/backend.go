@@ -0,0 +1,329 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// Backend is the interface for the storage of our queues, letting workers and task producers queue and pop
+// tasks without knowing where those tasks are kept
+type Backend interface {
+	// AddTask adds the passed in task to the passed in queue for execution
+	AddTask(queue string, taskType string, orgID int, task interface{}, priority Priority) error
+
+	// AddDelayedTask adds the passed in task to the passed in queue for execution no sooner than runAt
+	AddDelayedTask(queue string, taskType string, orgID int, task interface{}, runAt time.Time) error
+
+	// RetryTask queues the passed in failed task to be tried again no sooner than runAt, keeping its error count
+	RetryTask(queue string, task *Task, runAt time.Time) error
+
+	// PopNextTask pops and leases the next task from the passed in queue, returning nil if there are none
+	PopNextTask(queue string) (*Task, error)
+
+	// MarkTaskComplete releases the lease on the passed in popped task
+	MarkTaskComplete(queue string, task *Task) error
+
+	// ExtendTaskLease extends the lease on the passed in popped task so that it expires duration from now
+	ExtendTaskLease(queue string, task *Task, duration time.Duration) error
+
+	// ReapExpiredLeases requeues any tasks whose leases have expired, returning how many were requeued
+	ReapExpiredLeases(queue string) (int, error)
+
+	// SetConcurrencyLimits sets the maximum number of workers which can be handling tasks for a single org
+	SetConcurrencyLimits(queue string, defaultLimit int, orgLimits map[int]int) error
+
+	// Size returns the number of tasks waiting in the passed in queue
+	Size(queue string) (int, error)
+
+	// DelayedSize returns the number of tasks in the passed in queue which are waiting until they are due
+	DelayedSize(queue string) (int, error)
+
+	// InflightSize returns the number of tasks from the passed in queue which are currently leased by workers
+	InflightSize(queue string) (int, error)
+
+	// PauseOrg pauses the passed in org's tasks in the passed in queue, they will remain queued until resumed
+	PauseOrg(queue string, orgID int) error
+
+	// ResumeOrg resumes the passed in org's tasks in the passed in queue
+	ResumeOrg(queue string, orgID int) error
+
+	// OrgQueues returns the state of each org with tasks queued, being worked on or paused in the passed in queue
+	OrgQueues(queue string) ([]*OrgQueue, error)
+
+	// AddDeadLetter records the passed in task as permanently failed
+	AddDeadLetter(queue string, task *Task, errMsg string, stack string) (*DeadLetter, error)
+
+	// DeadLetterCount returns the number of dead letters for the passed in queue and org
+	DeadLetterCount(queue string, orgID int) (int, error)
+
+	// DeadLetters returns up to count dead letters for the passed in queue and org, starting at offset, newest first
+	DeadLetters(queue string, orgID int, offset int, count int) ([]*DeadLetter, error)
+
+	// RequeueDeadLetter adds the task of the dead letter with the passed in id back to its queue with its error
+	// count reset, returning the requeued letter or nil if it can't be found
+	RequeueDeadLetter(queue string, orgID int, id string) (*DeadLetter, error)
+
+	// PurgeDeadLetters removes all dead letters for the passed in queue and org
+	PurgeDeadLetters(queue string, orgID int) error
+
+	// Notifications returns a channel which receives a value whenever tasks are added to the passed in queue,
+	// until quit is closed. Notifications may be dropped or coalesced, callers should still poll occasionally.
+	Notifications(queue string, quit chan bool) <-chan bool
+}
+
+// defaultBackend is the backend used by code which isn't handed one, such as hooks, handlers and web endpoints
+var defaultBackend Backend
+
+// SetDefault sets the backend used by code which isn't handed one, mailroom sets this to its backend when started
+func SetDefault(b Backend) {
+	defaultBackend = b
+}
+
+// Default returns the backend set with SetDefault, or a redis backend using the passed in pool if none is set
+func Default(rp *redis.Pool) Backend {
+	if defaultBackend != nil {
+		return defaultBackend
+	}
+	return NewRedisBackend(rp)
+}
+
+// QueueTask adds the passed in task to the default backend. When none is set, as in tests and tools which don't
+// start mailroom, the task is added to redis using the passed in connection.
+func QueueTask(rc redis.Conn, queue string, taskType string, orgID int, task interface{}, priority Priority) error {
+	if defaultBackend != nil {
+		return defaultBackend.AddTask(queue, taskType, orgID, task, priority)
+	}
+	return AddTask(rc, queue, taskType, orgID, task, priority)
+}
+
+// QueueDelayedTask adds the passed in task to the default backend for execution no sooner than runAt. When none
+// is set the task is added to redis using the passed in connection.
+func QueueDelayedTask(rc redis.Conn, queue string, taskType string, orgID int, task interface{}, runAt time.Time) error {
+	if defaultBackend != nil {
+		return defaultBackend.AddDelayedTask(queue, taskType, orgID, task, runAt)
+	}
+	return AddDelayedTask(rc, queue, taskType, orgID, task, runAt)
+}
+
+// QueueDeadLetter records the passed in task as permanently failed in the default backend. When none is set the
+// letter is added to redis using the passed in connection.
+func QueueDeadLetter(rc redis.Conn, queue string, task *Task, errMsg string, stack string) (*DeadLetter, error) {
+	if defaultBackend != nil {
+		return defaultBackend.AddDeadLetter(queue, task, errMsg, stack)
+	}
+	return AddDeadLetter(rc, queue, task, errMsg, stack)
+}
+
+// RedisBackend is our queue backend which stores tasks in redis sorted sets
+type RedisBackend struct {
+	rp *redis.Pool
+}
+
+// NewRedisBackend creates a new queue backend which uses the passed in redis pool
+func NewRedisBackend(rp *redis.Pool) *RedisBackend {
+	return &RedisBackend{rp: rp}
+}
+
+// AddTask adds the passed in task to the passed in queue for execution
+func (b *RedisBackend) AddTask(queue string, taskType string, orgID int, task interface{}, priority Priority) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return AddTask(rc, queue, taskType, orgID, task, priority)
+}
+
+// AddDelayedTask adds the passed in task to the passed in queue for execution no sooner than runAt
+func (b *RedisBackend) AddDelayedTask(queue string, taskType string, orgID int, task interface{}, runAt time.Time) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return AddDelayedTask(rc, queue, taskType, orgID, task, runAt)
+}
+
+// RetryTask queues the passed in failed task to be tried again no sooner than runAt
+func (b *RedisBackend) RetryTask(queue string, task *Task, runAt time.Time) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return RetryTask(rc, queue, task, runAt)
+}
+
+// PopNextTask pops and leases the next task from the passed in queue
+func (b *RedisBackend) PopNextTask(queue string) (*Task, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return PopNextTask(rc, queue)
+}
+
+// MarkTaskComplete releases the lease on the passed in popped task
+func (b *RedisBackend) MarkTaskComplete(queue string, task *Task) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return MarkTaskComplete(rc, queue, task)
+}
+
+// ExtendTaskLease extends the lease on the passed in popped task
+func (b *RedisBackend) ExtendTaskLease(queue string, task *Task, duration time.Duration) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return ExtendTaskLease(rc, queue, task, duration)
+}
+
+// ReapExpiredLeases requeues any tasks whose leases have expired
+func (b *RedisBackend) ReapExpiredLeases(queue string) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return ReapExpiredLeases(rc, queue)
+}
+
+// SetConcurrencyLimits sets the maximum number of workers which can be handling tasks for a single org
+func (b *RedisBackend) SetConcurrencyLimits(queue string, defaultLimit int, orgLimits map[int]int) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return SetConcurrencyLimits(rc, queue, defaultLimit, orgLimits)
+}
+
+// Size returns the number of tasks waiting in the passed in queue
+func (b *RedisBackend) Size(queue string) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return Size(rc, queue)
+}
+
+// DelayedSize returns the number of tasks in the passed in queue which are waiting until they are due
+func (b *RedisBackend) DelayedSize(queue string) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return DelayedSize(rc, queue)
+}
+
+// InflightSize returns the number of tasks from the passed in queue which are currently leased by workers
+func (b *RedisBackend) InflightSize(queue string) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return InflightSize(rc, queue)
+}
+
+// PauseOrg pauses the passed in org's tasks in the passed in queue
+func (b *RedisBackend) PauseOrg(queue string, orgID int) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return PauseOrg(rc, queue, orgID)
+}
+
+// ResumeOrg resumes the passed in org's tasks in the passed in queue
+func (b *RedisBackend) ResumeOrg(queue string, orgID int) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return ResumeOrg(rc, queue, orgID)
+}
+
+// OrgQueues returns the state of each org with tasks queued, being worked on or paused in the passed in queue
+func (b *RedisBackend) OrgQueues(queue string) ([]*OrgQueue, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return OrgQueues(rc, queue)
+}
+
+// AddDeadLetter records the passed in task as permanently failed
+func (b *RedisBackend) AddDeadLetter(queue string, task *Task, errMsg string, stack string) (*DeadLetter, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return AddDeadLetter(rc, queue, task, errMsg, stack)
+}
+
+// DeadLetterCount returns the number of dead letters for the passed in queue and org
+func (b *RedisBackend) DeadLetterCount(queue string, orgID int) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return DeadLetterCount(rc, queue, orgID)
+}
+
+// DeadLetters returns up to count dead letters for the passed in queue and org, starting at offset, newest first
+func (b *RedisBackend) DeadLetters(queue string, orgID int, offset int, count int) ([]*DeadLetter, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return DeadLetters(rc, queue, orgID, offset, count)
+}
+
+// RequeueDeadLetter adds the task of the dead letter with the passed in id back to its queue
+func (b *RedisBackend) RequeueDeadLetter(queue string, orgID int, id string) (*DeadLetter, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return RequeueDeadLetter(rc, queue, orgID, id)
+}
+
+// PurgeDeadLetters removes all dead letters for the passed in queue and org
+func (b *RedisBackend) PurgeDeadLetters(queue string, orgID int) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+	return PurgeDeadLetters(rc, queue, orgID)
+}
+
+// Notifications returns a channel which receives a value whenever tasks are added to the passed in queue, this
+// holds a dedicated redis connection subscribed to the queue's notification channel until quit is closed. That
+// connection is dialed outside of our pool so that it doesn't count against the pool's active connections.
+func (b *RedisBackend) Notifications(queue string, quit chan bool) <-chan bool {
+	notify := make(chan bool, 1)
+
+	go func() {
+		for {
+			err := b.subscribe(queue, notify, quit)
+
+			select {
+			case <-quit:
+				return
+			default:
+			}
+
+			// our connection failed, wait a bit and resubscribe
+			logrus.WithError(err).WithField("queue", queue).Error("error receiving queue notifications")
+			time.Sleep(time.Second)
+		}
+	}()
+
+	return notify
+}
+
+// subscribe listens for notifications on our queue, returning when quit is closed or on error
+func (b *RedisBackend) subscribe(queue string, notify chan bool, quit chan bool) error {
+	conn, err := b.rp.Dial()
+	if err != nil {
+		return err
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	err = psc.Subscribe(fmt.Sprintf(notifyPattern, queue))
+	if err != nil {
+		return err
+	}
+
+	// unsubscribing will make our receive loop exit
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+			psc.Unsubscribe()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			select {
+			case notify <- true:
+			default:
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
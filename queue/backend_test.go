@@ -0,0 +1,246 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackends(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }}
+	rc := rp.Get()
+	defer rc.Close()
+
+	keys := []interface{}{"test:active", "test:delayed", "test:inflight", "test:limits", "test:paused", "test:1", "test:2", "test:dead", "test:dead:1", "test:dead:1:letters"}
+	rc.Do("del", keys...)
+	defer rc.Do("del", keys...)
+
+	testBackend(t, NewRedisBackend(rp))
+	testBackend(t, NewMemoryBackend())
+}
+
+func testBackend(t *testing.T, b Backend) {
+	assertPop := func(orgID int, body string) *Task {
+		task, err := b.PopNextTask("test")
+		assert.NoError(t, err)
+		if assert.NotNil(t, task) {
+			assert.Equal(t, orgID, task.OrgID)
+			assert.Equal(t, json.RawMessage(body), task.Task)
+		}
+		return task
+	}
+
+	task, err := b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	assert.NoError(t, b.AddTask("test", "campaign", 1, "task1", DefaultPriority))
+	assert.NoError(t, b.AddTask("test", "campaign", 1, "task2", DefaultPriority))
+	assert.NoError(t, b.AddTask("test", "campaign", 2, "task3", DefaultPriority))
+	assert.NoError(t, b.AddTask("test", "campaign", 1, "task4", HighPriority))
+	assert.NoError(t, b.AddDelayedTask("test", "campaign", 2, "task5", time.Now().Add(time.Hour)))
+
+	size, err := b.Size("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, size)
+
+	delayed, err := b.DelayedSize("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delayed)
+
+	// high priority tasks come first, then orgs take turns
+	task1 := assertPop(1, `"task4"`)
+
+	inflight, err := b.InflightSize("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inflight)
+	task2 := assertPop(2, `"task3"`)
+	task3 := assertPop(1, `"task1"`)
+
+	// limit org 1 to two workers, it already has those so there's nothing to pop
+	assert.NoError(t, b.SetConcurrencyLimits("test", 0, map[int]int{1: 2}))
+	task, err = b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	assert.NoError(t, b.ExtendTaskLease("test", task1, time.Minute))
+	assert.NoError(t, b.MarkTaskComplete("test", task1))
+	assert.NoError(t, b.MarkTaskComplete("test", task2))
+
+	// retried tasks keep their error count
+	task3.ErrorCount = 2
+	assert.NoError(t, b.RetryTask("test", task3, time.Now().Add(-time.Second)))
+	assert.NoError(t, b.MarkTaskComplete("test", task3))
+
+	retried := assertPop(1, `"task1"`)
+	assert.Equal(t, 2, retried.ErrorCount)
+	assertPop(1, `"task2"`)
+
+	reaped, err := b.ReapExpiredLeases("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+
+	letter, err := b.AddDeadLetter("test", retried, "boom", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "boom", letter.Error)
+	assert.Equal(t, 2, letter.Task.ErrorCount)
+	assert.NoError(t, b.MarkTaskComplete("test", retried))
+
+	_, err = b.AddDeadLetter("test", &Task{Type: "campaign", OrgID: 1, Task: json.RawMessage(`"task6"`), ErrorCount: 3}, "bang", "")
+	assert.NoError(t, err)
+
+	count, err := b.DeadLetterCount("test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	letters, err := b.DeadLetters("test", 1, 1, 10)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(letters)) {
+		assert.Equal(t, letter.ID, letters[0].ID)
+	}
+
+	// our delayed task isn't due yet
+	task, err = b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	// requeued dead letters start over without errors
+	requeued, err := b.RequeueDeadLetter("test", 1, letter.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, letter.ID, requeued.ID)
+
+	missing, err := b.RequeueDeadLetter("test", 1, letter.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+
+	// but aren't popped while their org is paused
+	assert.NoError(t, b.PauseOrg("test", 1))
+	task, err = b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	orgs, err := b.OrgQueues("test")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(orgs)) {
+		assert.Equal(t, 1, orgs[0].OrgID)
+		assert.Equal(t, 1, orgs[0].Size)
+		assert.True(t, orgs[0].Paused)
+		assert.Equal(t, map[string]int{"campaign": 1}, orgs[0].TaskTypes)
+	}
+
+	assert.NoError(t, b.ResumeOrg("test", 1))
+	task = assertPop(1, `"task1"`)
+	assert.Equal(t, 0, task.ErrorCount)
+	assert.NoError(t, b.MarkTaskComplete("test", task))
+
+	assert.NoError(t, b.PurgeDeadLetters("test", 1))
+	count, err = b.DeadLetterCount("test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestMemoryBackend(t *testing.T) {
+	b := NewMemoryBackend()
+
+	assert.NoError(t, b.AddTask("test", "campaign", 1, "task1", DefaultPriority))
+	task, err := b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+
+	// expire our lease, it should be requeued
+	assert.NoError(t, b.ExtendTaskLease("test", task, -time.Second))
+	reaped, err := b.ReapExpiredLeases("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reaped)
+
+	size, err := b.Size("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	// marking our original task complete is a noop as it has already been requeued
+	assert.NoError(t, b.MarkTaskComplete("test", task))
+
+	requeued, err := b.PopNextTask("test")
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`"task1"`), requeued.Task)
+
+	_, err = b.AddDeadLetter("test", requeued, "boom", "")
+	assert.NoError(t, err)
+	assert.NoError(t, b.MarkTaskComplete("test", requeued))
+
+	letters, err := b.DeadLetters("other", 1, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(letters))
+}
+
+func TestNotifications(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }}
+	rc := rp.Get()
+	defer rc.Close()
+	defer rc.Do("del", "test:active", "test:1")
+
+	for _, b := range []Backend{NewRedisBackend(rp), NewMemoryBackend()} {
+		quit := make(chan bool)
+		notifications := b.Notifications("test", quit)
+
+		// give our subscription a moment to be established
+		time.Sleep(100 * time.Millisecond)
+
+		// which shouldn't hold a connection from the pool, only our own is active
+		if _, isRedis := b.(*RedisBackend); isRedis {
+			assert.Equal(t, 1, rp.ActiveCount())
+		}
+
+		assert.NoError(t, b.AddTask("test", "campaign", 1, "task1", DefaultPriority))
+		select {
+		case <-notifications:
+		case <-time.After(time.Second):
+			assert.Fail(t, "no notification received for added task")
+		}
+
+		close(quit)
+	}
+}
+
+func TestDefaultBackend(t *testing.T) {
+	rp := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }}
+	rc := rp.Get()
+	defer rc.Close()
+	defer rc.Do("del", "test:active", "test:delayed", "test:1", "test:dead", "test:dead:1", "test:dead:1:letters")
+
+	// without a default backend, tasks are queued to redis
+	assert.NoError(t, QueueTask(rc, "test", "campaign", 1, "task1", DefaultPriority))
+	size, err := Default(rp).Size("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	// once one is set, producers queue to it instead
+	b := NewMemoryBackend()
+	SetDefault(b)
+	defer SetDefault(nil)
+
+	assert.Equal(t, b, Default(rp))
+	assert.NoError(t, QueueTask(rc, "test", "campaign", 1, "task2", DefaultPriority))
+	assert.NoError(t, QueueDelayedTask(rc, "test", "campaign", 1, "task3", time.Now().Add(time.Hour)))
+	_, err = QueueDeadLetter(rc, "test", &Task{Type: "campaign", OrgID: 1}, "boom", "")
+	assert.NoError(t, err)
+
+	size, err = b.Size("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	delayed, err := b.DelayedSize("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delayed)
+
+	count, err := b.DeadLetterCount("test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	size, err = Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	deadOrgsPattern    = "%s:dead"
+	deadLettersPattern = "%s:dead:%d"
+	deadByIDPattern    = "%s:dead:%d:letters"
+
+	// maxDeadLetters is the maximum number of dead letters we keep per queue and org, oldest are dropped first
+	maxDeadLetters = 10000
+)
+
+// DeadLetter is a task which failed permanently, along with the details of its last failure
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Queue    string    `json:"queue"`
+	Task     *Task     `json:"task"`
+	Error    string    `json:"error"`
+	Stack    string    `json:"stack,omitempty"`
+	FailedOn time.Time `json:"failed_on"`
+}
+
+// dead letter ids are kept in a sorted set by when they failed, and the letters themselves in a hash by id
+var addDeadLetter = redis.NewScript(3, `-- KEYS: [LettersKey, ByIDKey, OrgsKey] ARGV: [OrgID, LetterID, Score, Letter, MaxLetters]
+	redis.call("zadd", KEYS[1], ARGV[3], ARGV[2])
+	redis.call("hset", KEYS[2], ARGV[2], ARGV[4])
+	redis.call("sadd", KEYS[3], ARGV[1])
+
+	-- drop our oldest letters if we are over our limit
+	local dropped = redis.call("zrange", KEYS[1], 0, -(tonumber(ARGV[5]) + 1))
+	if #dropped > 0 then
+		redis.call("zremrangebyrank", KEYS[1], 0, -(tonumber(ARGV[5]) + 1))
+		redis.call("hdel", KEYS[2], unpack(dropped))
+	end
+`)
+
+// AddDeadLetter records the passed in task as permanently failed for the passed in queue
+func AddDeadLetter(rc redis.Conn, queue string, task *Task, errMsg string, stack string) (*DeadLetter, error) {
+	letter := &DeadLetter{
+		ID:       string(utils.NewUUID()),
+		Queue:    queue,
+		Task:     task,
+		Error:    errMsg,
+		Stack:    stack,
+		FailedOn: time.Now(),
+	}
+
+	letterJSON, err := json.Marshal(letter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling dead letter")
+	}
+
+	_, err = addDeadLetter.Do(rc, fmt.Sprintf(deadLettersPattern, queue, task.OrgID), fmt.Sprintf(deadByIDPattern, queue, task.OrgID),
+		fmt.Sprintf(deadOrgsPattern, queue), task.OrgID, letter.ID, timeScore(letter.FailedOn, DefaultPriority), letterJSON, maxDeadLetters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error adding dead letter for queue: %s", queue)
+	}
+
+	return letter, nil
+}
+
+// DeadLetterOrgs returns the ids of the orgs which have dead letters for the passed in queue
+func DeadLetterOrgs(rc redis.Conn, queue string) ([]int, error) {
+	orgIDs, err := redis.Ints(rc.Do("smembers", fmt.Sprintf(deadOrgsPattern, queue)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting dead letter orgs for queue: %s", queue)
+	}
+	return orgIDs, nil
+}
+
+// DeadLetterCount returns the number of dead letters for the passed in queue and org
+func DeadLetterCount(rc redis.Conn, queue string, orgID int) (int, error) {
+	count, err := redis.Int(rc.Do("zcard", fmt.Sprintf(deadLettersPattern, queue, orgID)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting dead letter count for queue: %s org: %d", queue, orgID)
+	}
+	return count, nil
+}
+
+// DeadLetters returns up to count dead letters for the passed in queue and org, starting at offset, newest first
+func DeadLetters(rc redis.Conn, queue string, orgID int, offset int, count int) ([]*DeadLetter, error) {
+	ids, err := redis.Values(rc.Do("zrevrange", fmt.Sprintf(deadLettersPattern, queue, orgID), offset, offset+count-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing dead letters for queue: %s org: %d", queue, orgID)
+	}
+	if len(ids) == 0 {
+		return []*DeadLetter{}, nil
+	}
+
+	values, err := redis.ByteSlices(rc.Do("hmget", redis.Args{}.Add(fmt.Sprintf(deadByIDPattern, queue, orgID)).Add(ids...)...))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading dead letters for queue: %s org: %d", queue, orgID)
+	}
+
+	letters := make([]*DeadLetter, 0, len(values))
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		letter := &DeadLetter{}
+		err = json.Unmarshal(v, letter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error unmarshalling dead letter: %s", string(v))
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns the dead letter with the passed in id, or nil if it can't be found
+func GetDeadLetter(rc redis.Conn, queue string, orgID int, id string) (*DeadLetter, error) {
+	value, err := redis.Bytes(rc.Do("hget", fmt.Sprintf(deadByIDPattern, queue, orgID), id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading dead letter: %s", id)
+	}
+
+	letter := &DeadLetter{}
+	err = json.Unmarshal(value, letter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling dead letter: %s", string(value))
+	}
+	return letter, nil
+}
+
+// RemoveDeadLetter removes and returns the dead letter with the passed in id, returning nil if it can't be found
+func RemoveDeadLetter(rc redis.Conn, queue string, orgID int, id string) (*DeadLetter, error) {
+	letter, err := GetDeadLetter(rc, queue, orgID, id)
+	if err != nil || letter == nil {
+		return nil, err
+	}
+
+	rc.Send("zrem", fmt.Sprintf(deadLettersPattern, queue, orgID), id)
+	rc.Send("hdel", fmt.Sprintf(deadByIDPattern, queue, orgID), id)
+	_, err = rc.Do("")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error removing dead letter: %s", id)
+	}
+	return letter, nil
+}
+
+// RequeueDeadLetter removes the dead letter with the passed in id and adds its task back to the queue
+// it failed in with its error count reset. It returns the requeued letter or nil if it can't be found
+func RequeueDeadLetter(rc redis.Conn, queue string, orgID int, id string) (*DeadLetter, error) {
+	letter, err := RemoveDeadLetter(rc, queue, orgID, id)
+	if err != nil || letter == nil {
+		return nil, err
+	}
+
+	task := *letter.Task
+	task.ErrorCount = 0
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling dead letter task")
+	}
+
+	rc.Send("zadd", fmt.Sprintf(queuePattern, queue, orgID), timeScore(time.Now(), DefaultPriority), taskJSON)
+	rc.Send("zincrby", fmt.Sprintf(activePattern, queue), 0, orgID)
+	rc.Send("publish", fmt.Sprintf(notifyPattern, queue), orgID)
+	_, err = rc.Do("")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error requeuing dead letter: %s", id)
+	}
+
+	return letter, nil
+}
+
+// PurgeDeadLetters removes all dead letters for the passed in queue and org
+func PurgeDeadLetters(rc redis.Conn, queue string, orgID int) error {
+	rc.Send("del", fmt.Sprintf(deadLettersPattern, queue, orgID), fmt.Sprintf(deadByIDPattern, queue, orgID))
+	rc.Send("srem", fmt.Sprintf(deadOrgsPattern, queue), strconv.Itoa(orgID))
+	_, err := rc.Do("")
+	if err != nil {
+		return errors.Wrapf(err, "error purging dead letters for queue: %s org: %d", queue, orgID)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "test:dead", "test:dead:1", "test:dead:1:letters")
+
+	task := &Task{Type: "campaign", OrgID: 1, Task: json.RawMessage(`"task1"`), ErrorCount: 3}
+	letter1, err := AddDeadLetter(rc, "test", task, "boom", "stack")
+	assert.NoError(t, err)
+
+	task = &Task{Type: "campaign", OrgID: 1, Task: json.RawMessage(`"task2"`), ErrorCount: 1}
+	letter2, err := AddDeadLetter(rc, "test", task, "bang", "")
+	assert.NoError(t, err)
+
+	orgs, err := DeadLetterOrgs(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, orgs)
+
+	count, err := DeadLetterCount(rc, "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// newest are listed first
+	letters, err := DeadLetters(rc, "test", 1, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(letters))
+	assert.Equal(t, letter2.ID, letters[0].ID)
+	assert.Equal(t, letter1.ID, letters[1].ID)
+
+	letter, err := GetDeadLetter(rc, "test", 1, letter1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "boom", letter.Error)
+	assert.Equal(t, "stack", letter.Stack)
+	assert.Equal(t, 3, letter.Task.ErrorCount)
+
+	letter, err = GetDeadLetter(rc, "test", 1, "missing")
+	assert.NoError(t, err)
+	assert.Nil(t, letter)
+
+	// requeue our first letter, should be back in our queue with its error count reset
+	letter, err = RequeueDeadLetter(rc, "test", 1, letter1.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, letter)
+
+	popped, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "campaign", popped.Type)
+	assert.Equal(t, 0, popped.ErrorCount)
+	assert.Equal(t, `"task1"`, string(popped.Task))
+
+	count, err = DeadLetterCount(rc, "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// purge the rest
+	assert.NoError(t, PurgeDeadLetters(rc, "test", 1))
+
+	count, err = DeadLetterCount(rc, "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	orgs, err = DeadLetterOrgs(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{}, orgs)
+}
@@ -0,0 +1,465 @@
+package queue
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/pkg/errors"
+)
+
+// MemoryBackend is a queue backend which keeps all tasks in memory. It is meant for tests and single node
+// embedded use, tasks are lost when the process exits.
+type MemoryBackend struct {
+	mutex  sync.Mutex
+	queues map[string]*memoryQueue
+}
+
+// memoryTask is a single queued task and the score it is ordered by
+type memoryTask struct {
+	task  *Task
+	score float64
+}
+
+// memoryQueue is the state of a single named queue
+type memoryQueue struct {
+	orgs         map[int][]*memoryTask
+	workers      map[int]int
+	delayed      []*memoryTask
+	inflight     map[*Task]time.Time
+	defaultLimit int
+	orgLimits    map[int]int
+	paused       map[int]bool
+	deadLetters  map[int][]*DeadLetter
+	listeners    []chan bool
+}
+
+// NewMemoryBackend creates a new empty in memory queue backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{queues: make(map[string]*memoryQueue)}
+}
+
+// getQueue returns the queue with the passed in name, creating it if necessary, caller must hold our mutex
+func (b *MemoryBackend) getQueue(name string) *memoryQueue {
+	q, found := b.queues[name]
+	if !found {
+		q = &memoryQueue{
+			orgs:      make(map[int][]*memoryTask),
+			workers:   make(map[int]int),
+			inflight:  make(map[*Task]time.Time),
+			orgLimits:   make(map[int]int),
+			paused:      make(map[int]bool),
+			deadLetters: make(map[int][]*DeadLetter),
+		}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// memoryScore returns the score for the passed in time and priority, matching the ordering of our redis queues
+func memoryScore(t time.Time, priority Priority) float64 {
+	return float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000) + float64(priority)
+}
+
+// newTask builds the task for the passed in values, encoding the task body the same as our redis queues
+func newTask(taskType string, orgID int, task interface{}) (*Task, error) {
+	taskBody, err := json.Marshal(task)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling task")
+	}
+
+	return &Task{
+		Type:     taskType,
+		OrgID:    orgID,
+		Task:     taskBody,
+		QueuedOn: time.Now(),
+	}, nil
+}
+
+// push adds the passed in task to its org's queue with the passed in score, caller must hold our mutex
+func (b *MemoryBackend) push(q *memoryQueue, task *Task, score float64) {
+	tasks := append(q.orgs[task.OrgID], &memoryTask{task: task, score: score})
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].score < tasks[j].score })
+	q.orgs[task.OrgID] = tasks
+}
+
+// AddTask adds the passed in task to the passed in queue for execution
+func (b *MemoryBackend) AddTask(queue string, taskType string, orgID int, task interface{}, priority Priority) error {
+	t, err := newTask(taskType, orgID, task)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(RouteTask(taskType, queue))
+	b.push(q, t, memoryScore(time.Now(), priority))
+	b.notify(q)
+	return nil
+}
+
+// notify lets anybody waiting on the passed in queue know there is a new task, caller must hold our mutex
+func (b *MemoryBackend) notify(q *memoryQueue) {
+	for _, listener := range q.listeners {
+		select {
+		case listener <- true:
+		default:
+		}
+	}
+}
+
+// AddDelayedTask adds the passed in task to the passed in queue for execution no sooner than runAt
+func (b *MemoryBackend) AddDelayedTask(queue string, taskType string, orgID int, task interface{}, runAt time.Time) error {
+	t, err := newTask(taskType, orgID, task)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(RouteTask(taskType, queue))
+	q.delayed = append(q.delayed, &memoryTask{task: t, score: memoryScore(runAt, DefaultPriority)})
+	return nil
+}
+
+// RetryTask queues the passed in failed task to be tried again no sooner than runAt, keeping its error count
+func (b *MemoryBackend) RetryTask(queue string, task *Task, runAt time.Time) error {
+	retry := *task
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	q.delayed = append(q.delayed, &memoryTask{task: &retry, score: memoryScore(runAt, DefaultPriority)})
+	return nil
+}
+
+// PopNextTask pops and leases the next task from the passed in queue, orgs with the fewest workers first
+func (b *MemoryBackend) PopNextTask(queue string) (*Task, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	now := time.Now()
+
+	// move any delayed tasks which are now due to their org queues
+	nowScore := memoryScore(now, DefaultPriority)
+	pending := q.delayed[:0]
+	for _, t := range q.delayed {
+		if t.score <= nowScore {
+			b.push(q, t.task, t.score)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	q.delayed = pending
+
+	// find the orgs with tasks which aren't paused and are below their limit of workers
+	orgIDs := make([]int, 0, len(q.orgs))
+	for orgID, tasks := range q.orgs {
+		if q.paused[orgID] {
+			continue
+		}
+		limit, found := q.orgLimits[orgID]
+		if !found {
+			limit = q.defaultLimit
+		}
+		if len(tasks) > 0 && (limit <= 0 || q.workers[orgID] < limit) {
+			orgIDs = append(orgIDs, orgID)
+		}
+	}
+	if len(orgIDs) == 0 {
+		return nil, nil
+	}
+
+	// pick the one with the fewest workers
+	sort.Slice(orgIDs, func(i, j int) bool {
+		if q.workers[orgIDs[i]] != q.workers[orgIDs[j]] {
+			return q.workers[orgIDs[i]] < q.workers[orgIDs[j]]
+		}
+		return orgIDs[i] < orgIDs[j]
+	})
+	orgID := orgIDs[0]
+
+	task := q.orgs[orgID][0].task
+	q.orgs[orgID] = q.orgs[orgID][1:]
+	if len(q.orgs[orgID]) == 0 {
+		delete(q.orgs, orgID)
+	}
+
+	q.workers[orgID]++
+	q.inflight[task] = now.Add(LeaseDuration)
+	return task, nil
+}
+
+// MarkTaskComplete releases the lease on the passed in popped task
+func (b *MemoryBackend) MarkTaskComplete(queue string, task *Task) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+
+	// if this task is no longer leased it has already been reaped and its worker released
+	if _, leased := q.inflight[task]; !leased {
+		return nil
+	}
+
+	delete(q.inflight, task)
+	b.releaseWorker(q, task.OrgID)
+	return nil
+}
+
+// releaseWorker decrements the number of workers on the passed in org, caller must hold our mutex
+func (b *MemoryBackend) releaseWorker(q *memoryQueue, orgID int) {
+	q.workers[orgID]--
+	if q.workers[orgID] <= 0 {
+		delete(q.workers, orgID)
+	}
+}
+
+// ExtendTaskLease extends the lease on the passed in popped task so that it expires duration from now
+func (b *MemoryBackend) ExtendTaskLease(queue string, task *Task, duration time.Duration) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	if _, leased := q.inflight[task]; leased {
+		q.inflight[task] = time.Now().Add(duration)
+	}
+	return nil
+}
+
+// ReapExpiredLeases requeues any tasks whose leases have expired, returning how many were requeued
+func (b *MemoryBackend) ReapExpiredLeases(queue string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	now := time.Now()
+
+	reaped := 0
+	for task, expiresOn := range q.inflight {
+		if expiresOn.After(now) {
+			continue
+		}
+
+		delete(q.inflight, task)
+		b.releaseWorker(q, task.OrgID)
+
+		requeued := *task
+		b.push(q, &requeued, memoryScore(now, DefaultPriority))
+		reaped++
+	}
+	return reaped, nil
+}
+
+// SetConcurrencyLimits sets the maximum number of workers which can be handling tasks for a single org
+func (b *MemoryBackend) SetConcurrencyLimits(queue string, defaultLimit int, orgLimits map[int]int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	q.defaultLimit = defaultLimit
+	q.orgLimits = make(map[int]int, len(orgLimits))
+	for orgID, limit := range orgLimits {
+		q.orgLimits[orgID] = limit
+	}
+	return nil
+}
+
+// Size returns the number of tasks waiting in the passed in queue
+func (b *MemoryBackend) Size(queue string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	size := 0
+	for _, tasks := range b.getQueue(queue).orgs {
+		size += len(tasks)
+	}
+	return size, nil
+}
+
+// DelayedSize returns the number of tasks in the passed in queue which are waiting until they are due
+func (b *MemoryBackend) DelayedSize(queue string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.getQueue(queue).delayed), nil
+}
+
+// InflightSize returns the number of tasks from the passed in queue which are currently leased by workers
+func (b *MemoryBackend) InflightSize(queue string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.getQueue(queue).inflight), nil
+}
+
+// PauseOrg pauses the passed in org's tasks in the passed in queue, they will remain queued until resumed
+func (b *MemoryBackend) PauseOrg(queue string, orgID int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.getQueue(queue).paused[orgID] = true
+	return nil
+}
+
+// ResumeOrg resumes the passed in org's tasks in the passed in queue
+func (b *MemoryBackend) ResumeOrg(queue string, orgID int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	delete(q.paused, orgID)
+	b.notify(q)
+	return nil
+}
+
+// OrgQueues returns the state of each org with tasks queued, being worked on or paused in the passed in queue
+func (b *MemoryBackend) OrgQueues(queue string) ([]*OrgQueue, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	orgs := make(map[int]*OrgQueue)
+	getOrg := func(orgID int) *OrgQueue {
+		if orgs[orgID] == nil {
+			orgs[orgID] = &OrgQueue{OrgID: orgID, TaskTypes: make(map[string]int)}
+		}
+		return orgs[orgID]
+	}
+
+	for orgID, tasks := range q.orgs {
+		org := getOrg(orgID)
+		org.Size = len(tasks)
+		for i, t := range tasks {
+			if i == orgQueueSample {
+				break
+			}
+			org.TaskTypes[t.task.Type]++
+			if i == 0 {
+				queuedOn := t.task.QueuedOn
+				org.Oldest = &queuedOn
+			}
+		}
+	}
+	for orgID, workers := range q.workers {
+		getOrg(orgID).Workers = workers
+	}
+	for orgID := range q.paused {
+		getOrg(orgID).Paused = true
+	}
+
+	queues := make([]*OrgQueue, 0, len(orgs))
+	for _, org := range orgs {
+		queues = append(queues, org)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].OrgID < queues[j].OrgID })
+	return queues, nil
+}
+
+// AddDeadLetter records the passed in task as permanently failed
+func (b *MemoryBackend) AddDeadLetter(queue string, task *Task, errMsg string, stack string) (*DeadLetter, error) {
+	failed := *task
+	letter := &DeadLetter{
+		ID:       string(utils.NewUUID()),
+		Queue:    queue,
+		Task:     &failed,
+		Error:    errMsg,
+		Stack:    stack,
+		FailedOn: time.Now(),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	letters := append(q.deadLetters[task.OrgID], letter)
+	if len(letters) > maxDeadLetters {
+		letters = letters[len(letters)-maxDeadLetters:]
+	}
+	q.deadLetters[task.OrgID] = letters
+	return letter, nil
+}
+
+// DeadLetterCount returns the number of dead letters for the passed in queue and org
+func (b *MemoryBackend) DeadLetterCount(queue string, orgID int) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.getQueue(queue).deadLetters[orgID]), nil
+}
+
+// DeadLetters returns up to count dead letters for the passed in queue and org, starting at offset, newest first
+func (b *MemoryBackend) DeadLetters(queue string, orgID int, offset int, count int) ([]*DeadLetter, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	oldestFirst := b.getQueue(queue).deadLetters[orgID]
+	letters := make([]*DeadLetter, 0, count)
+	for i := len(oldestFirst) - 1 - offset; i >= 0 && len(letters) < count; i-- {
+		letters = append(letters, oldestFirst[i])
+	}
+	return letters, nil
+}
+
+// RequeueDeadLetter adds the task of the dead letter with the passed in id back to its queue with its error
+// count reset, returning the requeued letter or nil if it can't be found
+func (b *MemoryBackend) RequeueDeadLetter(queue string, orgID int, id string) (*DeadLetter, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	q := b.getQueue(queue)
+	letters := q.deadLetters[orgID]
+	for i, letter := range letters {
+		if letter.ID == id {
+			q.deadLetters[orgID] = append(letters[:i:i], letters[i+1:]...)
+
+			task := *letter.Task
+			task.ErrorCount = 0
+			b.push(q, &task, memoryScore(time.Now(), DefaultPriority))
+			b.notify(q)
+			return letter, nil
+		}
+	}
+	return nil, nil
+}
+
+// PurgeDeadLetters removes all dead letters for the passed in queue and org
+func (b *MemoryBackend) PurgeDeadLetters(queue string, orgID int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.getQueue(queue).deadLetters, orgID)
+	return nil
+}
+
+// Notifications returns a channel which receives a value whenever tasks are added to the passed in queue
+func (b *MemoryBackend) Notifications(queue string, quit chan bool) <-chan bool {
+	notify := make(chan bool, 1)
+
+	b.mutex.Lock()
+	q := b.getQueue(queue)
+	q.listeners = append(q.listeners, notify)
+	b.mutex.Unlock()
+
+	// stop notifying once we are told to quit
+	go func() {
+		<-quit
+
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		for i, listener := range q.listeners {
+			if listener == notify {
+				q.listeners = append(q.listeners[:i], q.listeners[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	return notify
+}
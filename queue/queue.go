@@ -3,7 +3,9 @@ package queue
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -17,14 +19,29 @@ type Task struct {
 	Task       json.RawMessage `json:"task"`
 	QueuedOn   time.Time       `json:"queued_on"`
 	ErrorCount int             `json:"error_count,omitempty"`
+
+	// the raw payload this task was popped as, used to identify its lease
+	raw string
 }
 
 // Priority is the priority for the task
 type Priority int
 
 const (
-	queuePattern  = "%s:%d"
-	activePattern = "%s:active"
+	queuePattern    = "%s:%d"
+	activePattern   = "%s:active"
+	delayedPattern  = "%s:delayed"
+	inflightPattern = "%s:inflight"
+	limitsPattern   = "%s:limits"
+	pausedPattern   = "%s:paused"
+	notifyPattern   = "%s:notify"
+
+	// defaultLimitKey is the field in our limits hash which holds the limit for orgs without their own
+	defaultLimitKey = "default"
+
+	// LeaseDuration is how long a popped task is leased to a worker before it is considered lost and requeued,
+	// workers should extend their lease for as long as they are working on a task
+	LeaseDuration = time.Minute * 5
 
 	// DefaultPriority is the default priority for tasks
 	DefaultPriority = Priority(0)
@@ -41,6 +58,12 @@ const (
 	// HandlerQueue is our queue for message handling or other tasks related to just one contact
 	HandlerQueue = "handler"
 
+	// IVRQueue is our queue for starting IVR calls, kept separate so large batches don't delay calls
+	IVRQueue = "ivr"
+
+	// CampaignQueue is our queue for firing campaign events
+	CampaignQueue = "campaign"
+
 	// SendBroadcast is our type for sending a broadcast
 	SendBroadcast = "send_broadcast"
 
@@ -61,6 +84,9 @@ const (
 
 	// StartIVRFlowBatch is our task for starting an ivr batch
 	StartIVRFlowBatch = "start_ivr_flow_batch"
+
+	// MergeContacts is our task for merging one contact into another
+	MergeContacts = "merge_contacts"
 )
 
 // Size returns the number of tasks for the passed in queue
@@ -84,9 +110,43 @@ func Size(rc redis.Conn, queue string) (int, error) {
 	return size, nil
 }
 
-// AddTask adds the passed in task to our queue for execution
+// timeScore returns the sorted set score for the passed in time and priority, that is seconds since epoch
+// with microsecond precision offset by our priority
+func timeScore(t time.Time, priority Priority) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000)+float64(priority), 'f', 6, 64)
+}
+
+var routes = make(map[string]string)
+var routesMutex sync.RWMutex
+
+// SetRoutes sets which task types should be added to a named queue rather than the queue they are added to
+func SetRoutes(taskRoutes map[string]string) {
+	routesMutex.Lock()
+	defer routesMutex.Unlock()
+
+	routes = make(map[string]string, len(taskRoutes))
+	for taskType, queue := range taskRoutes {
+		routes[taskType] = queue
+	}
+}
+
+// RouteTask returns the queue that tasks of the passed in type should be added to, which is the passed in queue
+// unless that task type has been routed elsewhere
+func RouteTask(taskType string, queue string) string {
+	routesMutex.RLock()
+	defer routesMutex.RUnlock()
+
+	routed, found := routes[taskType]
+	if found {
+		return routed
+	}
+	return queue
+}
+
+// AddTask adds the passed in task to our queue for execution, or the queue its type is routed to
 func AddTask(rc redis.Conn, queue string, taskType string, orgID int, task interface{}, priority Priority) error {
-	score := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000)+float64(priority), 'f', 6, 64)
+	queue = RouteTask(taskType, queue)
+	score := timeScore(time.Now(), priority)
 
 	taskBody, err := json.Marshal(task)
 	if err != nil {
@@ -106,77 +166,335 @@ func AddTask(rc redis.Conn, queue string, taskType string, orgID int, task inter
 
 	rc.Send("zadd", fmt.Sprintf(queuePattern, queue, orgID), score, jsonPayload)
 	rc.Send("zincrby", fmt.Sprintf(activePattern, queue), 0, orgID)
+	rc.Send("publish", fmt.Sprintf(notifyPattern, queue), orgID)
 	_, err = rc.Do("")
 	return err
 }
 
-var popTask = redis.NewScript(1, `-- KEYS: [QueueName]
-    -- first get what is the active queue
-	local result = redis.call("zrange", KEYS[1] .. ":active", 0, 0, "WITHSCORES")
+// DelayedSize returns the number of delayed tasks for the passed in queue which are not yet due
+func DelayedSize(rc redis.Conn, queue string) (int, error) {
+	count, err := redis.Int(rc.Do("zcard", fmt.Sprintf(delayedPattern, queue)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting delayed size for: %s", queue)
+	}
+	return count, nil
+}
 
-	-- nothing? return nothing
-	local group = result[1]
-	if not group then
-		return {"empty", ""}
-	end
+// AddDelayedTask adds the passed in task to our queue for execution no sooner than runAt. Delayed tasks
+// are moved to their org's queue when they become due, at which point they are popped like any other task.
+func AddDelayedTask(rc redis.Conn, queue string, taskType string, orgID int, task interface{}, runAt time.Time) error {
+	queue = RouteTask(taskType, queue)
+	score := timeScore(runAt, DefaultPriority)
 
-	local queue = KEYS[1] .. ":" .. group
+	taskBody, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	payload := &Task{
+		Type:     taskType,
+		OrgID:    orgID,
+		Task:     taskBody,
+		QueuedOn: time.Now(),
+	}
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
 
-	-- pop off our queue
-	local result = redis.call("zrangebyscore", queue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+	_, err = rc.Do("zadd", fmt.Sprintf(delayedPattern, queue), score, jsonPayload)
+	return err
+}
 
-	-- found a result?
-	if result[1] then
-		-- then remove it from the queue
-		redis.call('zremrangebyrank', queue, 0, 0)
+var popTask = redis.NewScript(1, `-- KEYS: [QueueName] ARGV: [Now, LeaseExpiration]
+	-- move any delayed tasks which are now due to their org queues, using their due time as their score
+	local due = redis.call("zrangebyscore", KEYS[1] .. ":delayed", "-inf", ARGV[1], "WITHSCORES", "LIMIT", 0, 100)
+	for i = 1, #due, 2 do
+		local group = tostring(cjson.decode(due[i])["org_id"])
+		redis.call("zadd", KEYS[1] .. ":" .. group, due[i + 1], due[i])
+		redis.call("zincrby", KEYS[1] .. ":active", 0, group)
+		redis.call("zrem", KEYS[1] .. ":delayed", due[i])
+	end
 
-		-- and add a worker to this queue
-		redis.call("zincrby", KEYS[1] .. ":active", 1, group)
+	-- then get our active queues, those with the fewest workers first
+	local groups = redis.call("zrange", KEYS[1] .. ":active", 0, -1, "WITHSCORES")
 
-		return {group, result[1]}
-	else
-		-- no result found, remove this group from active queues
-		redis.call("zrem", KEYS[1] .. ":active", group)
+	-- nothing? return nothing
+	if not groups[1] then
+		return {"empty", ""}
+	end
 
-		return {"retry", ""}
+	-- pop from the first queue which isn't paused, is below its limit of concurrent workers (zero meaning no limit)
+	-- and has a task waiting
+	local defaultLimit = tonumber(redis.call("hget", KEYS[1] .. ":limits", "default") or "0")
+	for i = 1, #groups, 2 do
+		local group = groups[i]
+		local workers = tonumber(groups[i + 1])
+		local limit = tonumber(redis.call("hget", KEYS[1] .. ":limits", group) or defaultLimit)
+		local paused = redis.call("sismember", KEYS[1] .. ":paused", group) == 1
+
+		if not paused and (limit <= 0 or workers < limit) then
+			local queue = KEYS[1] .. ":" .. group
+			local result = redis.call("zrangebyscore", queue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+
+			-- found a result?
+			if result[1] then
+				-- then remove it from the queue
+				redis.call('zremrangebyrank', queue, 0, 0)
+
+				-- and lease it to our caller until it is marked complete
+				redis.call("zadd", KEYS[1] .. ":inflight", ARGV[2], result[1])
+
+				-- and add a worker to this queue
+				redis.call("zincrby", KEYS[1] .. ":active", 1, group)
+
+				return {group, result[1]}
+			end
+
+			-- no result found, this group is no longer active unless it still has workers, whose count we need to
+			-- keep so that its limit still holds if more tasks are added before they complete
+			if workers <= 0 then
+				redis.call("zrem", KEYS[1] .. ":active", group)
+			end
+		end
 	end
+
+	-- every queue is paused, at its limit or empty, return nothing
+	return {"empty", ""}
 `)
 
-// PopNextTask pops the next task off our queue
+// PopNextTask pops the next task off our queue. The task is leased to the caller for LeaseDuration, if it
+// isn't marked complete or has its lease extended in that time it will be requeued
 func PopNextTask(rc redis.Conn, queue string) (*Task, error) {
+	now := time.Now()
+	values, err := redis.Strings(popTask.Do(rc, queue, timeScore(now, DefaultPriority), timeScore(now.Add(LeaseDuration), DefaultPriority)))
+	if err != nil {
+		return nil, err
+	}
+
+	if values[0] == "empty" {
+		return nil, nil
+	}
+
 	task := Task{}
-	for {
-		values, err := redis.Strings(popTask.Do(rc, queue))
+	err = json.Unmarshal([]byte(values[1]), &task)
+	task.raw = values[1]
+	return &task, err
+}
+
+// SetConcurrencyLimits sets the maximum number of workers which can be handling tasks for a single org in the
+// passed in queue. The default limit applies to all orgs not present in orgLimits, a limit of zero means no limit.
+func SetConcurrencyLimits(rc redis.Conn, queue string, defaultLimit int, orgLimits map[int]int) error {
+	limitsKey := fmt.Sprintf(limitsPattern, queue)
+
+	rc.Send("multi")
+	rc.Send("del", limitsKey)
+	rc.Send("hset", limitsKey, defaultLimitKey, defaultLimit)
+	for orgID, limit := range orgLimits {
+		rc.Send("hset", limitsKey, orgID, limit)
+	}
+	_, err := rc.Do("exec")
+	if err != nil {
+		return errors.Wrapf(err, "error setting concurrency limits for queue: %s", queue)
+	}
+	return nil
+}
+
+// ConcurrencyLimits returns the default concurrency limit for the passed in queue and any org specific limits
+func ConcurrencyLimits(rc redis.Conn, queue string) (int, map[int]int, error) {
+	values, err := redis.IntMap(rc.Do("hgetall", fmt.Sprintf(limitsPattern, queue)))
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error getting concurrency limits for queue: %s", queue)
+	}
+
+	defaultLimit := 0
+	orgLimits := make(map[int]int, len(values))
+	for key, limit := range values {
+		if key == defaultLimitKey {
+			defaultLimit = limit
+			continue
+		}
+
+		orgID, err := strconv.Atoi(key)
 		if err != nil {
-			return nil, err
+			return 0, nil, errors.Wrapf(err, "invalid org id in concurrency limits: %s", key)
 		}
+		orgLimits[orgID] = limit
+	}
+
+	return defaultLimit, orgLimits, nil
+}
+
+// PauseOrg pauses the passed in org's tasks in the passed in queue, they will remain queued until resumed
+func PauseOrg(rc redis.Conn, queue string, orgID int) error {
+	_, err := rc.Do("sadd", fmt.Sprintf(pausedPattern, queue), orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error pausing org: %d in queue: %s", orgID, queue)
+	}
+	return nil
+}
+
+// ResumeOrg resumes the passed in org's tasks in the passed in queue
+func ResumeOrg(rc redis.Conn, queue string, orgID int) error {
+	_, err := rc.Do("srem", fmt.Sprintf(pausedPattern, queue), orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error resuming org: %d in queue: %s", orgID, queue)
+	}
+	return nil
+}
 
-		if values[0] == "empty" {
-			return nil, nil
+// OrgQueue is the current state of a single org's tasks in a queue. Oldest is when the next task to be popped was
+// queued and TaskTypes only counts the types of the next few tasks, so that inspecting a large queue stays cheap.
+type OrgQueue struct {
+	OrgID     int            `json:"org_id"`
+	Size      int            `json:"size"`
+	Workers   int            `json:"workers"`
+	Paused    bool           `json:"paused"`
+	Oldest    *time.Time     `json:"oldest,omitempty"`
+	TaskTypes map[string]int `json:"task_types"`
+}
+
+// orgQueueSample is how many of the next tasks in an org's queue we decode to count their types
+const orgQueueSample = 100
+
+// OrgQueues returns the state of each org with tasks queued or being worked on in the passed in queue
+func OrgQueues(rc redis.Conn, queue string) ([]*OrgQueue, error) {
+	workers, err := redis.IntMap(rc.Do("zrange", fmt.Sprintf(activePattern, queue), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting active queues for: %s", queue)
+	}
+
+	paused, err := redis.Ints(rc.Do("smembers", fmt.Sprintf(pausedPattern, queue)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting paused orgs for: %s", queue)
+	}
+
+	orgs := make(map[int]*OrgQueue, len(workers))
+	for group, count := range workers {
+		orgID, err := strconv.Atoi(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid org id in active queues: %s", group)
+		}
+		orgs[orgID] = &OrgQueue{OrgID: orgID, Workers: count, TaskTypes: make(map[string]int)}
+	}
+	for _, orgID := range paused {
+		if orgs[orgID] == nil {
+			orgs[orgID] = &OrgQueue{OrgID: orgID, TaskTypes: make(map[string]int)}
 		}
+		orgs[orgID].Paused = true
+	}
 
-		if values[0] == "retry" {
-			continue
+	queues := make([]*OrgQueue, 0, len(orgs))
+	for orgID, org := range orgs {
+		orgQueue := fmt.Sprintf(queuePattern, queue, orgID)
+
+		rc.Send("zcard", orgQueue)
+		rc.Send("zrange", orgQueue, 0, orgQueueSample-1)
+		replies, err := redis.Values(rc.Do(""))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting tasks for org: %d in queue: %s", orgID, queue)
+		}
+
+		org.Size, err = redis.Int(replies[0], nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting size for org: %d in queue: %s", orgID, queue)
 		}
 
-		err = json.Unmarshal([]byte(values[1]), &task)
-		return &task, err
+		tasks, err := redis.ByteSlices(replies[1], nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting tasks for org: %d in queue: %s", orgID, queue)
+		}
+
+		for i, t := range tasks {
+			task := &Task{}
+			err = json.Unmarshal(t, task)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error unmarshalling task: %s", string(t))
+			}
+
+			org.TaskTypes[task.Type]++
+			if i == 0 {
+				org.Oldest = &task.QueuedOn
+			}
+		}
+		queues = append(queues, org)
+	}
+
+	sort.Slice(queues, func(i, j int) bool { return queues[i].OrgID < queues[j].OrgID })
+	return queues, nil
+}
+
+// ExtendTaskLease extends the lease on the passed in popped task so that it expires duration from now
+func ExtendTaskLease(rc redis.Conn, queue string, task *Task, duration time.Duration) error {
+	_, err := rc.Do("zadd", fmt.Sprintf(inflightPattern, queue), "XX", timeScore(time.Now().Add(duration), DefaultPriority), task.raw)
+	if err != nil {
+		return errors.Wrapf(err, "error extending lease for task in queue: %s", queue)
+	}
+	return nil
+}
+
+// InflightSize returns the number of tasks which have been popped from the passed in queue but not yet completed
+func InflightSize(rc redis.Conn, queue string) (int, error) {
+	count, err := redis.Int(rc.Do("zcard", fmt.Sprintf(inflightPattern, queue)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting inflight size for: %s", queue)
 	}
+	return count, nil
 }
 
-var markComplete = redis.NewScript(2, `-- KEYS: [QueueName] [TaskGroup]
+var reapLeases = redis.NewScript(1, `-- KEYS: [QueueName] ARGV: [Now]
+	local expired = redis.call("zrangebyscore", KEYS[1] .. ":inflight", "-inf", ARGV[1], "LIMIT", 0, 1000)
+	for _, task in ipairs(expired) do
+		local group = tostring(cjson.decode(task)["org_id"])
+
+		-- put our task back on its org queue
+		redis.call("zrem", KEYS[1] .. ":inflight", task)
+		redis.call("zadd", KEYS[1] .. ":" .. group, ARGV[1], task)
+
+		-- and release the worker it was assigned to
+		local active = tonumber(redis.call("zincrby", KEYS[1] .. ":active", -1, group))
+		if active < 0 then
+			redis.call("zadd", KEYS[1] .. ":active", 0, group)
+		end
+	end
+	return #expired
+`)
+
+// ReapExpiredLeases requeues any tasks in the passed in queue whose leases have expired, presumably because
+// the process working on them died. It returns the number of tasks requeued.
+func ReapExpiredLeases(rc redis.Conn, queue string) (int, error) {
+	count, err := redis.Int(reapLeases.Do(rc, queue, timeScore(time.Now(), DefaultPriority)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reaping expired leases for queue: %s", queue)
+	}
+	return count, nil
+}
+
+var markComplete = redis.NewScript(2, `-- KEYS: [QueueName] [TaskGroup] ARGV: [Task]
+	-- release our lease, if we no longer have one our task was requeued and the worker already released
+	local leased = redis.call("zrem", KEYS[1] .. ":inflight", ARGV[1])
+	if leased == 0 and ARGV[1] ~= "" then
+		return
+	end
+
 	-- decrement our active
 	local active = tonumber(redis.call("zincrby", KEYS[1] .. ":active", -1, KEYS[2]))
 
 	-- reset to zero if we somehow go below
 	if active < 0 then
+		active = 0
 		redis.call("zadd", KEYS[1] .. ":active", 0, KEYS[2])
 	end
+
+	-- if that was our last worker and there are no more tasks, this group is no longer active
+	if active == 0 and redis.call("zcard", KEYS[1] .. ":" .. KEYS[2]) == 0 then
+		redis.call("zrem", KEYS[1] .. ":active", KEYS[2])
+	end
 `)
 
-// MarkTaskComplete marks the passed in task as complete. Callers must call this in order
+// MarkTaskComplete marks the passed in task as complete, releasing its lease. Callers must call this in order
 // to maintain fair workers across orgs
-func MarkTaskComplete(rc redis.Conn, queue string, orgID int) error {
-	_, err := markComplete.Do(rc, queue, strconv.FormatInt(int64(orgID), 10))
+func MarkTaskComplete(rc redis.Conn, queue string, task *Task) error {
+	_, err := markComplete.Do(rc, queue, strconv.FormatInt(int64(task.OrgID), 10), task.raw)
 	return err
 }
@@ -3,6 +3,7 @@ package queue
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
@@ -11,7 +12,7 @@ import (
 func TestQueues(t *testing.T) {
 	rc, err := redis.Dial("tcp", "localhost:6379")
 	assert.NoError(t, err)
-	rc.Do("del", "test:active", "test:1", "test:2", "test:3")
+	rc.Do("del", "test:active", "test:inflight", "test:1", "test:2", "test:3")
 
 	popPriority := Priority(-1)
 	markCompletePriority := Priority(-2)
@@ -26,6 +27,7 @@ func TestQueues(t *testing.T) {
 	}{
 		{"test", 1, "campaign", "task1", DefaultPriority, 1},
 		{"test", 1, "campaign", "task1", popPriority, 0},
+		{"test", 1, "campaign", "", markCompletePriority, 0},
 		{"test", 1, "campaign", "", popPriority, 0},
 		{"test", 1, "campaign", "task1", DefaultPriority, 1},
 		{"test", 1, "campaign", "task2", DefaultPriority, 2},
@@ -65,7 +67,7 @@ func TestQueues(t *testing.T) {
 			assert.NoError(t, json.Unmarshal(task.Task, &value), "%d: error unmarshalling", i)
 			assert.Equal(t, value, tc.Task, "%d: task mismatch", i)
 		} else if tc.Priority == markCompletePriority {
-			assert.NoError(t, MarkTaskComplete(rc, tc.Queue, tc.TaskGroup))
+			assert.NoError(t, MarkTaskComplete(rc, tc.Queue, &Task{OrgID: tc.TaskGroup}))
 		} else {
 			assert.NoError(t, AddTask(rc, tc.Queue, tc.TaskType, tc.TaskGroup, tc.Task, tc.Priority))
 		}
@@ -75,3 +77,283 @@ func TestQueues(t *testing.T) {
 		assert.Equal(t, tc.Size, size, "%d: mismatch", i)
 	}
 }
+
+func TestDelayedTasks(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:delayed", "test:1", "test:2")
+
+	// add a delayed task that is already due and one far in the future
+	assert.NoError(t, AddDelayedTask(rc, "test", "campaign", 1, "due", time.Now().Add(-time.Second)))
+	assert.NoError(t, AddDelayedTask(rc, "test", "campaign", 2, "future", time.Now().Add(time.Hour)))
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	delayed, err := DelayedSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delayed)
+
+	// popping should promote our due task and return it
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+	assert.Equal(t, 1, task.OrgID)
+
+	var value string
+	assert.NoError(t, json.Unmarshal(task.Task, &value))
+	assert.Equal(t, "due", value)
+
+	// our future task isn't due yet
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	delayed, err = DelayedSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delayed)
+}
+
+func TestLeases(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:inflight", "test:1")
+
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "task1", DefaultPriority))
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "task2", DefaultPriority))
+
+	// popping a task leases it
+	task1, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	task2, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+
+	inflight, err := InflightSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inflight)
+
+	// completing our first task releases its lease
+	assert.NoError(t, MarkTaskComplete(rc, "test", task1))
+
+	inflight, err = InflightSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inflight)
+
+	// nothing to reap while our lease is valid
+	reaped, err := ReapExpiredLeases(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+
+	// expire our second lease, it should be requeued
+	assert.NoError(t, ExtendTaskLease(rc, "test", task2, -time.Second))
+	reaped, err = ReapExpiredLeases(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reaped)
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	// completing a reaped task is a noop
+	assert.NoError(t, MarkTaskComplete(rc, "test", task2))
+	active, err := redis.Int(rc.Do("zscore", "test:active", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, active)
+
+	// and we can pop it again
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+
+	var value string
+	assert.NoError(t, json.Unmarshal(task.Task, &value))
+	assert.Equal(t, "task2", value)
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:inflight", "test:limits", "test:1", "test:2")
+
+	// org 1 can only have one worker, everyone else two
+	assert.NoError(t, SetConcurrencyLimits(rc, "test", 2, map[int]int{1: 1}))
+
+	defaultLimit, orgLimits, err := ConcurrencyLimits(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, defaultLimit)
+	assert.Equal(t, map[int]int{1: 1}, orgLimits)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, AddTask(rc, "test", "campaign", 1, "org1", DefaultPriority))
+		assert.NoError(t, AddTask(rc, "test", "campaign", 2, "org2", DefaultPriority))
+	}
+
+	// pop tasks until we are throttled, org 1 should only get one worker, org 2 two
+	popped := make(map[int]int)
+	for {
+		task, err := PopNextTask(rc, "test")
+		assert.NoError(t, err)
+		if task == nil {
+			break
+		}
+		popped[task.OrgID]++
+	}
+	assert.Equal(t, map[int]int{1: 1, 2: 2}, popped)
+
+	// completing a task for org 1 frees it up for another
+	assert.NoError(t, MarkTaskComplete(rc, "test", &Task{OrgID: 1}))
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, task.OrgID)
+
+	// removing our limits lets everything through
+	assert.NoError(t, SetConcurrencyLimits(rc, "test", 0, nil))
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	for i := 0; i < size; i++ {
+		task, err := PopNextTask(rc, "test")
+		assert.NoError(t, err)
+		assert.NotNil(t, task)
+	}
+}
+
+func TestConcurrencyLimitsWhenDrained(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:inflight", "test:limits", "test:paused", "test:1")
+
+	assert.NoError(t, SetConcurrencyLimits(rc, "test", 1, nil))
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "task1", DefaultPriority))
+
+	task1, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, task1)
+
+	// org 1's queue is now empty, popping again finds nothing but keeps the count of its worker
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	orgs, err := OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(orgs))
+	assert.Equal(t, 1, orgs[0].Workers)
+
+	// so a new task for org 1 still can't be popped while the first is in flight
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "task2", DefaultPriority))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	// until it is completed
+	assert.NoError(t, MarkTaskComplete(rc, "test", task1))
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+	assert.Equal(t, `"task2"`, string(task.Task))
+
+	// once that completes too the org is no longer active
+	assert.NoError(t, MarkTaskComplete(rc, "test", task))
+
+	orgs, err = OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(orgs))
+}
+
+func TestPausedOrgs(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:inflight", "test:limits", "test:paused", "test:1", "test:2")
+
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "org1", DefaultPriority))
+	assert.NoError(t, AddTask(rc, "test", "start", 2, "org2", DefaultPriority))
+	assert.NoError(t, AddTask(rc, "test", "start", 2, "org2", DefaultPriority))
+	assert.NoError(t, PauseOrg(rc, "test", 1))
+
+	orgs, err := OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(orgs))
+	assert.Equal(t, 1, orgs[0].OrgID)
+	assert.True(t, orgs[0].Paused)
+	assert.Equal(t, 1, orgs[0].Size)
+	assert.Equal(t, map[string]int{"campaign": 1}, orgs[0].TaskTypes)
+	assert.NotNil(t, orgs[0].Oldest)
+	assert.Equal(t, 2, orgs[1].OrgID)
+	assert.False(t, orgs[1].Paused)
+	assert.Equal(t, 2, orgs[1].Size)
+	assert.Equal(t, map[string]int{"start": 2}, orgs[1].TaskTypes)
+
+	// only org 2's tasks can be popped
+	for i := 0; i < 2; i++ {
+		task, err := PopNextTask(rc, "test")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, task.OrgID)
+	}
+
+	orgs, err = OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, orgs[1].Workers)
+	assert.Equal(t, 0, orgs[1].Size)
+
+	task, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	// until we resume org 1
+	assert.NoError(t, ResumeOrg(rc, "test", 1))
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, task.OrgID)
+}
+
+func TestOrgQueuesSample(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:inflight", "test:limits", "test:paused", "test:1")
+
+	for i := 0; i < orgQueueSample+50; i++ {
+		assert.NoError(t, AddTask(rc, "test", "start", 1, "org1", DefaultPriority))
+	}
+
+	// size is the whole queue but only the next tasks have their types counted
+	orgs, err := OrgQueues(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(orgs))
+	assert.Equal(t, orgQueueSample+50, orgs[0].Size)
+	assert.Equal(t, map[string]int{"start": orgQueueSample}, orgs[0].TaskTypes)
+	assert.NotNil(t, orgs[0].Oldest)
+}
+
+func TestRoutes(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:1", "routed:active", "routed:1")
+	defer rc.Do("del", "routed:active", "routed:1")
+
+	SetRoutes(map[string]string{"ivr_start": "routed"})
+	defer SetRoutes(nil)
+
+	assert.Equal(t, "routed", RouteTask("ivr_start", "test"))
+	assert.Equal(t, "test", RouteTask("campaign", "test"))
+
+	assert.NoError(t, AddTask(rc, "test", "ivr_start", 1, "task1", DefaultPriority))
+	assert.NoError(t, AddTask(rc, "test", "campaign", 1, "task2", DefaultPriority))
+
+	size, err := Size(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	task, err := PopNextTask(rc, "routed")
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+	assert.Equal(t, "ivr_start", task.Type)
+
+	task, err = PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+	assert.Equal(t, "campaign", task.Type)
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy describes how a task which fails should be retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a task will be tried, including its first attempt
+	MaxAttempts int
+
+	// InitialBackoff is how long we wait before the first retry, each subsequent retry waits Multiplier times longer
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the longest we will ever wait between attempts
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor our backoff grows by after each retry
+	Multiplier float64
+
+	// Retryable decides whether the passed in error is worth retrying, if nil all errors are retried
+	Retryable func(error) bool
+}
+
+// NoRetries is the policy for tasks which should never be retried
+var NoRetries = &RetryPolicy{MaxAttempts: 1}
+
+// DefaultRetryPolicy retries tasks which fail with transient errors up to 3 times with exponential backoff
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Second * 15,
+	MaxBackoff:     time.Minute * 10,
+	Multiplier:     4,
+	Retryable:      IsTransientError,
+}
+
+// ShouldRetry returns whether the passed in task, which has failed ErrorCount times with its last error
+// being err, should be tried again
+func (p *RetryPolicy) ShouldRetry(task *Task, err error) bool {
+	if p == nil || task.ErrorCount >= p.MaxAttempts {
+		return false
+	}
+	if IsPermanentError(err) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Backoff returns how long we should wait before retrying a task which has failed errorCount times
+func (p *RetryPolicy) Backoff(errorCount int) time.Duration {
+	if errorCount < 1 {
+		errorCount = 1
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(errorCount-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// permanentError wraps an error which should never be retried
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Cause() error { return e.error }
+
+// PermanentError wraps the passed in error so that no retry policy will retry the task that returned it
+func PermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsPermanentError returns whether the passed in error, or any error it wraps, was marked as permanent
+func IsPermanentError(err error) bool {
+	for err != nil {
+		if _, isPermanent := err.(*permanentError); isPermanent {
+			return true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+	return false
+}
+
+// IsTransientError returns whether the passed in error looks like a temporary database, redis or network
+// failure which is likely to succeed if tried again
+func IsTransientError(err error) bool {
+	cause := errors.Cause(err)
+
+	switch cause {
+	case driver.ErrBadConn, sql.ErrConnDone, context.DeadlineExceeded, redis.ErrPoolExhausted:
+		return true
+	}
+
+	switch typed := cause.(type) {
+	case net.Error:
+		return true
+	case *pq.Error:
+		// connection exceptions, serialization failures, deadlocks, resource issues and admin shutdowns
+		switch typed.Code.Class() {
+		case "08", "40", "53", "57":
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryTask queues the passed in task to be tried again once runAt is reached, keeping its error count
+func RetryTask(rc redis.Conn, queue string, task *Task, runAt time.Time) error {
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling task for retry")
+	}
+
+	_, err = rc.Do("zadd", fmt.Sprintf(delayedPattern, queue), timeScore(runAt, DefaultPriority), taskJSON)
+	if err != nil {
+		return errors.Wrapf(err, "error queuing task for retry")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Second * 10, Multiplier: 4}
+
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, time.Second*4, policy.Backoff(2))
+	assert.Equal(t, time.Second*10, policy.Backoff(3))
+
+	transient := errors.Wrapf(driver.ErrBadConn, "error loading contacts")
+	other := errors.New("invalid flow")
+
+	assert.True(t, policy.ShouldRetry(&Task{ErrorCount: 1}, other))
+	assert.True(t, policy.ShouldRetry(&Task{ErrorCount: 2}, transient))
+	assert.False(t, policy.ShouldRetry(&Task{ErrorCount: 3}, transient))
+	assert.False(t, policy.ShouldRetry(&Task{ErrorCount: 1}, errors.Wrapf(PermanentError(other), "error starting flow")))
+
+	assert.False(t, NoRetries.ShouldRetry(&Task{ErrorCount: 1}, transient))
+	assert.False(t, (*RetryPolicy)(nil).ShouldRetry(&Task{ErrorCount: 1}, transient))
+
+	assert.True(t, DefaultRetryPolicy.ShouldRetry(&Task{ErrorCount: 1}, transient))
+	assert.False(t, DefaultRetryPolicy.ShouldRetry(&Task{ErrorCount: 1}, other))
+
+	assert.True(t, IsTransientError(transient))
+	assert.True(t, IsTransientError(&pq.Error{Code: "40P01"}))
+	assert.False(t, IsTransientError(&pq.Error{Code: "23505"}))
+	assert.False(t, IsTransientError(other))
+	assert.False(t, IsTransientError(nil))
+}
+
+func TestRetryTask(t *testing.T) {
+	rc, err := redis.Dial("tcp", "localhost:6379")
+	assert.NoError(t, err)
+	rc.Do("del", "test:active", "test:delayed", "test:inflight", "test:1")
+
+	task := &Task{Type: "campaign", OrgID: 1, Task: json.RawMessage(`"task1"`), QueuedOn: time.Now(), ErrorCount: 2}
+	assert.NoError(t, RetryTask(rc, "test", task, time.Now().Add(-time.Second)))
+
+	delayed, err := DelayedSize(rc, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delayed)
+
+	// our retried task keeps its error count
+	popped, err := PopNextTask(rc, "test")
+	assert.NoError(t, err)
+	assert.NotNil(t, popped)
+	assert.Equal(t, 2, popped.ErrorCount)
+	assert.Equal(t, json.RawMessage(`"task1"`), popped.Task)
+}
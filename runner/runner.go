@@ -30,6 +30,7 @@ func NewStartOptions() *StartOptions {
 		RestartParticipants: true,
 		IncludeActive:       true,
 		Interrupt:           true,
+		LockTimeout:         time.Minute * 5,
 	}
 	return start
 }
@@ -50,6 +51,14 @@ type StartOptions struct {
 
 	// TriggerBuilder is the builder that will be used to build a trigger for each contact started in the flow
 	TriggerBuilder TriggerBuilder
+
+	// LockTimeout is how long we keep retrying contacts whose locks are held by others, such as while an incoming
+	// message is being handled for them
+	LockTimeout time.Duration
+
+	// LockedHook is called with the contacts we were unable to lock within our lock timeout, before the sessions
+	// of our last attempt are committed
+	LockedHook func(contactIDs []models.ContactID)
 }
 
 // TriggerBuilder defines the interface for building a trigger for the passed in contact
@@ -297,6 +306,15 @@ func FireCampaignEvents(
 		return contactIDs, nil
 	}
 
+	// contacts who are locked, such as while an incoming message is handled, aren't waited on or skipped, we leave
+	// their fires unfired so that they are queued again on the next run of our cron
+	options.LockTimeout = 0
+	options.LockedHook = func(contactIDs []models.ContactID) {
+		for _, contactID := range contactIDs {
+			delete(skippedContacts, contactID)
+		}
+	}
+
 	// our builder for the triggers that will be created for contacts
 	flowRef := assets.NewFlowReference(flow.UUID(), flow.Name())
 	options.TriggerBuilder = func(contact *flows.Contact) flows.Trigger {
@@ -430,79 +448,110 @@ func StartFlow(
 	}
 
 	// we now need to grab locks for our contacts so that they are never in two starts or handles at the
-	// same time we try to grab locks for up to five minutes, but do it in batches where we wait for one
-	// second per contact to prevent deadlocks
+	// same time, we grab all the locks we can at once, start those contacts, then retry those who were
+	// locked until our lock timeout
 	sessions := make([]*models.Session, 0, len(includedContacts))
 	remaining := includedContacts
 	start := time.Now()
 
-	// map of locks we've released
-	released := make(map[string]bool)
+	for len(remaining) > 0 {
+		lockIDs := make([]string, len(remaining))
+		contactsByLock := make(map[string]models.ContactID, len(remaining))
+		for i, contactID := range remaining {
+			lockIDs[i] = models.ContactLock(org.OrgID(), contactID)
+			contactsByLock[lockIDs[i]] = contactID
+		}
+
+		lockedIDs, lockValue, err := locker.GrabLocks(rp, lockIDs, time.Minute*5)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error attempting to grab locks")
+		}
 
-	for len(remaining) > 0 && time.Since(start) < time.Minute*5 {
-		locked := make([]models.ContactID, 0, len(remaining))
-		locks := make([]string, 0, len(remaining))
-		skipped := make([]models.ContactID, 0, 5)
+		locked := make([]models.ContactID, len(lockedIDs))
+		for i, lockID := range lockedIDs {
+			locked[i] = contactsByLock[lockID]
+			delete(contactsByLock, lockID)
+		}
 
-		// try up to a second to get a lock for a contact
+		skipped := make([]models.ContactID, 0, len(contactsByLock))
 		for _, contactID := range remaining {
-			lockID := models.ContactLock(org.OrgID(), contactID)
-			lock, err := locker.GrabLock(rp, lockID, time.Minute*5, time.Second)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error attempting to grab lock")
-			}
-			if lock == "" {
+			if _, isSkipped := contactsByLock[models.ContactLock(org.OrgID(), contactID)]; isSkipped {
 				skipped = append(skipped, contactID)
-				continue
 			}
-			locked = append(locked, contactID)
-			locks = append(locks, lock)
-
-			// defer unlocking if we exit due to error
-			defer func() {
-				if !released[lockID] {
-					locker.ReleaseLock(rp, lockID, lock)
-				}
-			}()
 		}
 
-		// load our locked contacts
-		contacts, err := models.LoadContacts(ctx, db, org, locked)
-		if err != nil {
-			return nil, errors.Wrapf(err, "error loading contacts to start")
+		// if this is our last try, let our caller know who we're giving up on before we commit anything
+		lastTry := time.Since(start) >= options.LockTimeout
+		if lastTry && len(skipped) > 0 && options.LockedHook != nil {
+			options.LockedHook(skipped)
 		}
 
-		// ok, we've filtered our contacts, build our triggers
-		triggers := make([]flows.Trigger, 0, len(locked))
-		for _, c := range contacts {
-			contact, err := c.FlowContact(org, sa)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error creating flow contact")
-			}
-			triggers = append(triggers, options.TriggerBuilder(contact))
+		ss, err := startLockedContacts(ctx, db, rp, org, sa, flow, locked, options)
+
+		// release all our locks
+		rerr := locker.ReleaseLocks(rp, lockedIDs, lockValue)
+		if rerr != nil {
+			logrus.WithError(rerr).WithField("flow_uuid", flow.UUID()).Error("error releasing contact locks")
 		}
 
-		ss, err := StartFlowForContacts(ctx, db, rp, org, sa, flow, triggers, options.CommitHook, options.Interrupt)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error starting flow for contacts")
+			return nil, err
 		}
 
 		// append all the sessions that were started
-		for _, s := range ss {
-			sessions = append(sessions, s)
+		sessions = append(sessions, ss...)
+
+		// skipped are now our remaining
+		remaining = skipped
+		if lastTry || len(remaining) == 0 {
+			break
 		}
 
-		// release all our locks
-		for i := range locked {
-			lockID := models.ContactLock(org.OrgID(), locked[i])
-			locker.ReleaseLock(rp, lockID, locks[i])
-			released[lockID] = true
+		// give whoever holds the locks of those remaining a moment to finish
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "context done while waiting for contact locks")
+		case <-time.After(time.Second):
 		}
+	}
 
-		// skipped are now our remaining
-		remaining = skipped
+	if len(remaining) > 0 {
+		logrus.WithField("flow_uuid", flow.UUID()).WithField("contact_ids", remaining).Info("skipped starting locked contacts")
+		librato.Gauge("mr.flow_start_locked_count", float64(len(remaining)))
+	}
+
+	return sessions, nil
+}
+
+// startLockedContacts loads and starts the passed in contacts, whose locks we hold, in the passed in flow
+func startLockedContacts(
+	ctx context.Context, db *sqlx.DB, rp *redis.Pool, org *models.OrgAssets, sa flows.SessionAssets,
+	flow *models.Flow, contactIDs []models.ContactID, options *StartOptions) ([]*models.Session, error) {
+
+	if len(contactIDs) == 0 {
+		return nil, nil
+	}
+
+	// load our locked contacts
+	contacts, err := models.LoadContacts(ctx, db, org, contactIDs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading contacts to start")
 	}
 
+	// ok, we've filtered our contacts, build our triggers
+	triggers := make([]flows.Trigger, 0, len(contacts))
+	for _, c := range contacts {
+		contact, err := c.FlowContact(org, sa)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating flow contact")
+		}
+		triggers = append(triggers, options.TriggerBuilder(contact))
+	}
+
+	sessions, err := StartFlowForContacts(ctx, db, rp, org, sa, flow, triggers, options.CommitHook, options.Interrupt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error starting flow for contacts")
+	}
 	return sessions, nil
 }
 
@@ -718,7 +767,7 @@ func TriggerIVRFlow(ctx context.Context, db *sqlx.DB, rp *redis.Pool, orgID mode
 	// queue this to our ivr starter, it will take care of creating the connections then calling back in
 	rc := rp.Get()
 	defer rc.Close()
-	err = queue.AddTask(rc, queue.BatchQueue, queue.StartIVRFlowBatch, int(orgID), task, queue.HighPriority)
+	err = queue.QueueTask(rc, queue.BatchQueue, queue.StartIVRFlowBatch, int(orgID), task, queue.HighPriority)
 	if err != nil {
 		return errors.Wrapf(err, "error queuing ivr flow start")
 	}
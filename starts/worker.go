@@ -24,8 +24,9 @@ const (
 )
 
 func init() {
-	mailroom.AddTaskFunction(queue.StartFlow, handleFlowStart)
-	mailroom.AddTaskFunction(queue.StartFlowBatch, handleFlowStartBatch)
+	// these aren't retried as contacts may have already been created or started when they fail
+	mailroom.AddTaskFunction(queue.StartFlow, handleFlowStart, nil)
+	mailroom.AddTaskFunction(queue.StartFlowBatch, handleFlowStartBatch, nil)
 }
 
 // handleFlowStart creates all the batches of contacts to start in a flow
@@ -43,11 +44,24 @@ func handleFlowStart(ctx context.Context, mr *mailroom.Mailroom, task *queue.Tas
 		return errors.Wrapf(err, "error unmarshalling flow start task: %s", string(task.Task))
 	}
 
-	return CreateFlowBatches(ctx, mr.DB, mr.RP, startTask)
+	return CreateFlowBatches(ctx, mr.DB, mr.RP, mr.Queue, startTask)
 }
 
-// CreateFlowBatches takes our master flow start and creates batches of flow starts for all the unique contacts
-func CreateFlowBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, start *models.FlowStart) error {
+// CreateFlowBatches takes our master flow start and creates batches of flow starts for all the unique contacts,
+// queuing them to the passed in queue backend
+func CreateFlowBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, queues queue.Backend, start *models.FlowStart) error {
+	// if this start was cancelled before we got to it, there's nothing to do
+	rc := rp.Get()
+	cancelled, err := models.IsFlowStartCancelled(rc, start.ID())
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		logrus.WithField("start_id", start.ID()).Info("skipping cancelled flow start")
+		return nil
+	}
+
 	// we are building a set of contact ids, start with the explicit ones
 	contactIDs := make(map[models.ContactID]bool)
 	for _, id := range start.ContactIDs() {
@@ -56,7 +70,6 @@ func CreateFlowBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, start *
 
 	var org *models.OrgAssets
 	var assets flows.SessionAssets
-	var err error
 
 	// look up any contacts by URN
 	if len(start.URNs()) > 0 {
@@ -116,9 +129,6 @@ func CreateFlowBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, start *
 		}
 	}
 
-	rc := rp.Get()
-	defer rc.Close()
-
 	// by default we start in the batch queue unless we have two or fewer contacts
 	q := queue.BatchQueue
 	if len(contactIDs) <= 2 {
@@ -135,7 +145,7 @@ func CreateFlowBatches(ctx context.Context, db *sqlx.DB, rp *redis.Pool, start *
 	queueBatch := func(last bool) {
 		batch := start.CreateBatch(contacts)
 		batch.SetIsLast(last)
-		err = queue.AddTask(rc, q, taskType, int(start.OrgID()), batch, queue.DefaultPriority)
+		err = queues.AddTask(q, taskType, int(start.OrgID()), batch, queue.DefaultPriority)
 		if err != nil {
 			// TODO: is continuing the right thing here? what do we do if redis is down? (panic!)
 			logrus.WithError(err).WithField("start_id", start.ID()).Error("error while queuing start")
@@ -180,6 +190,18 @@ func handleFlowStartBatch(ctx context.Context, mr *mailroom.Mailroom, task *queu
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
+	// if our start has been cancelled, skip this batch
+	rc := mr.RP.Get()
+	cancelled, err := models.IsFlowStartCancelled(rc, startBatch.StartID())
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		logrus.WithField("start_id", startBatch.StartID()).Info("skipping batch for cancelled flow start")
+		return nil
+	}
+
 	// start these contacts in our flow
 	_, err = runner.StartFlowBatch(ctx, mr.DB, mr.RP, startBatch)
 	if err != nil {
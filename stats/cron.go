@@ -2,6 +2,7 @@ package stats
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -23,11 +24,16 @@ func init() {
 
 // StartStatsCron starts our cron job of posting stats every minute
 func StartStatsCron(mr *mailroom.Mailroom) error {
+	namedQueues, err := mr.Config.NamedQueues()
+	if err != nil {
+		return err
+	}
+
 	cron.StartCron(mr.Quit, mr.RP, expirationLock, time.Second*60,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
-			return dumpStats(ctx, mr.DB, mr.RP, lockName, lockValue)
+			return dumpStats(ctx, mr.DB, mr.RP, mr.Queue, namedQueues, lockName, lockValue)
 		},
 	)
 	return nil
@@ -39,33 +45,60 @@ var (
 )
 
 // dumpStats calculates a bunch of stats every minute and both logs them and posts them to librato
-func dumpStats(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName string, lockValue string) error {
+func dumpStats(ctx context.Context, db *sqlx.DB, rp *redis.Pool, queues queue.Backend, namedQueues map[string]int, lockName string, lockValue string) error {
 	// get our DB status
 	stats := db.Stats()
 
-	rc := rp.Get()
-	defer rc.Close()
-
 	// calculate size of batch queue
-	batchSize, err := queue.Size(rc, queue.BatchQueue)
+	batchSize, err := queues.Size(queue.BatchQueue)
 	if err != nil {
 		logrus.WithError(err).Error("error calculating batch queue size")
 	}
 
 	// and size of handler queue
-	handlerSize, err := queue.Size(rc, queue.HandlerQueue)
+	handlerSize, err := queues.Size(queue.HandlerQueue)
 	if err != nil {
 		logrus.WithError(err).Error("error calculating handler queue size")
 	}
 
-	logrus.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"db_idle":      stats.Idle,
 		"db_busy":      stats.InUse,
 		"db_waiting":   stats.WaitCount - waitCount,
 		"db_wait":      stats.WaitDuration - waitDuration,
 		"batch_size":   batchSize,
 		"handler_size": handlerSize,
-	}).Info("current stats")
+	}
+
+	// and the size of each of our named queues
+	for name := range namedQueues {
+		size, err := queues.Size(name)
+		if err != nil {
+			logrus.WithError(err).WithField("queue", name).Error("error calculating queue size")
+		}
+		fields[fmt.Sprintf("%s_size", name)] = size
+		librato.Gauge(fmt.Sprintf("mr.%s_queue", name), float64(size))
+	}
+
+	// and how long since each of our crons last fired, so we can alert when one stops
+	rc := rp.Get()
+	crons, err := cron.GetInfos(rc)
+	rc.Close()
+	if err != nil {
+		logrus.WithError(err).Error("error getting cron statuses")
+	}
+	for _, c := range crons {
+		if c.LastRun.LastStart != nil {
+			librato.Gauge(fmt.Sprintf("mr.cron_%s_age", c.Name), float64(time.Since(*c.LastRun.LastStart))/float64(time.Second))
+		}
+		if c.Overdue {
+			librato.Gauge(fmt.Sprintf("mr.cron_%s_overdue", c.Name), 1)
+		} else {
+			librato.Gauge(fmt.Sprintf("mr.cron_%s_overdue", c.Name), 0)
+		}
+	}
+
+	logrus.WithFields(fields).Info("current stats")
 
 	librato.Gauge("mr.handler_queue", float64(handlerSize))
 	librato.Gauge("mr.batch_queue", float64(batchSize))
@@ -10,17 +10,20 @@ import (
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/cron"
 	"github.com/nyaruka/mailroom/handler"
-	"github.com/nyaruka/mailroom/marker"
+	"github.com/nyaruka/mailroom/idempotency"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	timeoutLock = "sessions_timeouts"
-	markerGroup = "session_timeouts"
+	timeoutLock      = "sessions_timeouts"
+	timeoutBatchSize = 500
 )
 
+// queuedTimeouts is the group of timeouts which have been queued to be handled
+var queuedTimeouts = idempotency.NewGroup("session_timeouts", time.Hour*24)
+
 func init() {
 	mailroom.AddInitFunction(StartTimeoutCron)
 }
@@ -28,8 +31,8 @@ func init() {
 // StartTimeoutCron starts our cron job of continuing timed out sessions every minute
 func StartTimeoutCron(mr *mailroom.Mailroom) error {
 	cron.StartCron(mr.Quit, mr.RP, timeoutLock, time.Second*60,
-		func(lockName string, lockValue string) error {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 			return timeoutSessions(ctx, mr.DB, mr.RP, lockName, lockValue)
 		},
@@ -38,7 +41,6 @@ func StartTimeoutCron(mr *mailroom.Mailroom) error {
 }
 
 // timeoutRuns looks for any runs that have timed out and schedules for them to continue
-// TODO: extend lock
 func timeoutSessions(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName string, lockValue string) error {
 	log := logrus.WithField("comp", "timeout").WithField("lock", lockValue)
 	start := time.Now()
@@ -53,45 +55,65 @@ func timeoutSessions(ctx context.Context, db *sqlx.DB, rp *redis.Pool, lockName
 	rc := rp.Get()
 	defer rc.Close()
 
-	// add a timeout task for each run
+	// add a timeout task for each run, in batches
 	count := 0
-	timeout := &Timeout{}
+	batch := make([]*Timeout, 0, timeoutBatchSize)
 	for rows.Next() {
+		timeout := &Timeout{}
 		err := rows.StructScan(timeout)
 		if err != nil {
 			return errors.Wrapf(err, "error scanning timeout")
 		}
 
-		// check whether we've already queued this
-		taskID := fmt.Sprintf("%d:%s", timeout.SessionID, timeout.TimeoutOn.Format(time.RFC3339))
-		queued, err := marker.HasTask(rc, markerGroup, taskID)
-		if err != nil {
-			return errors.Wrapf(err, "error checking whether task is queued")
+		batch = append(batch, timeout)
+		if len(batch) == timeoutBatchSize {
+			queuedCount, err := queueTimeouts(rc, batch)
+			if err != nil {
+				return err
+			}
+			count += queuedCount
+			batch = batch[:0]
 		}
+	}
 
-		// already queued? move on
-		if queued {
-			continue
-		}
+	// queue any stragglers
+	queuedCount, err := queueTimeouts(rc, batch)
+	if err != nil {
+		return err
+	}
+	count += queuedCount
 
-		// ok, queue this task
+	log.WithField("elapsed", time.Since(start)).WithField("count", count).Info("timeouts queued")
+	return nil
+}
+
+// queueTimeouts queues handle tasks for those of the passed in timeouts which haven't already been queued
+func queueTimeouts(rc redis.Conn, timeouts []*Timeout) (int, error) {
+	timeoutsByTask := make(map[string]*Timeout, len(timeouts))
+	taskIDs := make([]string, len(timeouts))
+	for i, timeout := range timeouts {
+		taskIDs[i] = fmt.Sprintf("%d:%s", timeout.SessionID, timeout.TimeoutOn.Format(time.RFC3339))
+		timeoutsByTask[taskIDs[i]] = timeout
+	}
+
+	// mark those which haven't already been queued as queued
+	unqueued, err := queuedTimeouts.CheckAndAdd(rc, taskIDs)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error marking timeout tasks as queued")
+	}
+
+	for i, taskID := range unqueued {
+		timeout := timeoutsByTask[taskID]
 		task := handler.NewTimeoutTask(timeout.OrgID, timeout.ContactID, timeout.SessionID, timeout.TimeoutOn)
 		err = handler.AddHandleTask(rc, timeout.ContactID, task)
 		if err != nil {
-			return errors.Wrapf(err, "error adding new handle task")
-		}
-
-		// and mark it as queued
-		err = marker.AddTask(rc, markerGroup, taskID)
-		if err != nil {
-			return errors.Wrapf(err, "error marking timeout task as queued")
+			// unmark those we didn't get to so that they are queued next time
+			queuedTimeouts.Remove(rc, unqueued[i:]...)
+			return i, errors.Wrapf(err, "error adding new handle task")
 		}
-
-		count++
 	}
 
-	log.WithField("elapsed", time.Since(start)).WithField("count", count).Info("timeouts queued")
-	return nil
+	return len(unqueued), nil
 }
 
 const timedoutSessionsSQL = `
@@ -7,7 +7,6 @@ import (
 
 	"github.com/nyaruka/mailroom/handler"
 	_ "github.com/nyaruka/mailroom/hooks"
-	"github.com/nyaruka/mailroom/marker"
 	"github.com/nyaruka/mailroom/models"
 	"github.com/nyaruka/mailroom/queue"
 	"github.com/nyaruka/mailroom/testsuite"
@@ -21,7 +20,7 @@ func TestTimeouts(t *testing.T) {
 	rc := testsuite.RC()
 	defer rc.Close()
 
-	err := marker.ClearTasks(rc, timeoutLock)
+	err := queuedTimeouts.Clear(rc)
 	assert.NoError(t, err)
 
 	// need to create a session that has an expired timeout
@@ -0,0 +1,51 @@
+package broadcast
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/cancel", web.RequireAuthToken(handleCancel))
+}
+
+// Cancels a broadcast which hasn't finished sending. Any batches which haven't yet been sent will be
+// skipped, batches already being sent will run to completion. The broadcast is marked as failed.
+//
+//   {
+//     "org_id": 1,
+//     "broadcast_id": 12345
+//   }
+//
+type cancelRequest struct {
+	OrgID       models.OrgID       `json:"org_id"       validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id" validate:"required"`
+}
+
+func handleCancel(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &cancelRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	rc := s.RP.Get()
+	defer rc.Close()
+
+	cancelled, err := models.CancelBroadcast(ctx, s.DB, rc, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !cancelled {
+		return nil, http.StatusNotFound, errors.Errorf("no unsent broadcast with id: %d", request.BroadcastID)
+	}
+
+	logrus.WithField("org_id", request.OrgID).WithField("broadcast_id", request.BroadcastID).Info("broadcast cancelled")
+
+	return map[string]interface{}{"broadcast_id": request.BroadcastID, "cancelled": true}, http.StatusOK, nil
+}
@@ -0,0 +1,78 @@
+package broadcast
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/config"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	ctx, db, rp := testsuite.Reset()
+	wg := &sync.WaitGroup{}
+
+	server := web.NewServer(ctx, config.Mailroom, db, rp, nil, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	var broadcastID models.BroadcastID
+	err := db.Get(&broadcastID,
+		`INSERT INTO msgs_broadcast(status, text, base_language, is_active, created_on, modified_on, purged, send_all, created_by_id, modified_by_id, org_id)
+		 VALUES('Q', '"base"=>"hi"'::hstore, 'base', TRUE, NOW(), NOW(), FALSE, FALSE, 1, 1, $1) RETURNING id`, models.Org1)
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		URL      string
+		Method   string
+		Body     string
+		Status   int
+		Response string
+	}{
+		{"/mr/broadcast/cancel", "GET", "", 405, "illegal"},
+		{"/mr/broadcast/cancel", "POST", `{"org_id": 1}`, 400, "request failed validation"},
+		{"/mr/broadcast/cancel", "POST", `{"org_id": 2, "broadcast_id": %d}`, 404, "no unsent broadcast"},
+		{"/mr/broadcast/cancel", "POST", `{"org_id": 1, "broadcast_id": %d}`, 200, `"cancelled": true`},
+		{"/mr/broadcast/cancel", "POST", `{"org_id": 1, "broadcast_id": %d}`, 404, "no unsent broadcast"},
+	}
+
+	for i, tc := range tcs {
+		// fill in the id of our broadcast
+		body := strings.Replace(tc.Body, "%d", fmt.Sprint(broadcastID), 1)
+
+		req, err := http.NewRequest(tc.Method, "http://localhost:8090"+tc.URL, bytes.NewReader([]byte(body)))
+		assert.NoError(t, err, "%d: error creating request", i)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err, "%d: error making request", i)
+
+		assert.Equal(t, tc.Status, resp.StatusCode, "%d: unexpected status", i)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "%d: error reading body", i)
+
+		assert.True(t, strings.Contains(string(content), tc.Response), "%d: did not find string: %s in body: %s", i, tc.Response, string(content))
+	}
+
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM msgs_broadcast WHERE id = $1 AND status = 'F'`, []interface{}{broadcastID}, 1)
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	cancelled, err := models.IsBroadcastCancelled(rc, broadcastID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+}
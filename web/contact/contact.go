@@ -0,0 +1,199 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/contacts"
+	"github.com/nyaruka/mailroom/handler"
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodGet, "/mr/contact/{org_id:[0-9]+}/{contact_id:[0-9]+}/events", web.RequireAuthToken(handleEvents))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/{org_id:[0-9]+}/{contact_id:[0-9]+}/events/drop", web.RequireAuthToken(handleDrop))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/{org_id:[0-9]+}/{contact_id:[0-9]+}/unlock", web.RequireAuthToken(handleUnlock))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/{org_id:[0-9]+}/{contact_id:[0-9]+}/merge", web.RequireAuthToken(handleMerge))
+}
+
+// Lists the events waiting to be handled for a contact, in the order they will be handled, and whether the
+// contact is currently locked by a handler. Who is inspecting the events must be passed as the actor query
+// parameter, ex: ?actor=bob@nyaruka.com
+//
+//   {
+//     "org_id": 1,
+//     "contact_id": 12345,
+//     "locked": true,
+//     "events": [{
+//       "index": 0,
+//       "type": "msg_event",
+//       "queued_on": "2019-02-05T20:33:10.123456Z",
+//       "error_count": 2,
+//       "task": {...}
+//     }]
+//   }
+//
+type eventsResponse struct {
+	OrgID     models.OrgID            `json:"org_id"`
+	ContactID models.ContactID        `json:"contact_id"`
+	Locked    bool                    `json:"locked"`
+	Events    []*handler.ContactEvent `json:"events"`
+}
+
+func handleEvents(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	actor := r.URL.Query().Get("actor")
+	if actor == "" {
+		return nil, http.StatusBadRequest, errors.Errorf("missing actor query parameter")
+	}
+
+	orgID, contactID := contactFromURL(r)
+
+	rc := s.RP.Get()
+	defer rc.Close()
+
+	events, err := handler.ContactEvents(rc, orgID, contactID, actor)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error inspecting contact events")
+	}
+
+	locked, err := locker.IsLocked(s.RP, models.ContactLock(orgID, contactID))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &eventsResponse{OrgID: orgID, ContactID: contactID, Locked: locked, Events: events}, http.StatusOK, nil
+}
+
+// Drops a single event waiting to be handled for a contact. The type of the event must match, so that a different
+// event isn't dropped if the contact's events have changed since they were listed.
+//
+//   {
+//     "index": 0,
+//     "type": "msg_event",
+//     "actor": "bob@nyaruka.com"
+//   }
+//
+type dropRequest struct {
+	Index *int   `json:"index" validate:"required"`
+	Type  string `json:"type"  validate:"required"`
+	Actor string `json:"actor" validate:"required"`
+}
+
+func handleDrop(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &dropRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	if *request.Index < 0 {
+		return nil, http.StatusBadRequest, errors.Errorf("index can't be negative")
+	}
+
+	orgID, contactID := contactFromURL(r)
+
+	rc := s.RP.Get()
+	defer rc.Close()
+
+	dropped, err := handler.DropContactEvent(rc, orgID, contactID, *request.Index, request.Type, request.Actor)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !dropped {
+		return nil, http.StatusNotFound, errors.Errorf("no %s event at index %d", request.Type, *request.Index)
+	}
+
+	return map[string]interface{}{"org_id": orgID, "contact_id": contactID, "dropped": true}, http.StatusOK, nil
+}
+
+// Force releases the lock on a contact whose handler is stuck, and queues a task to handle any events
+// waiting for it.
+//
+//   {
+//     "actor": "bob@nyaruka.com"
+//   }
+//
+type unlockRequest struct {
+	Actor string `json:"actor" validate:"required"`
+}
+
+func handleUnlock(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &unlockRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	orgID, contactID := contactFromURL(r)
+
+	released, err := handler.UnlockContact(s.RP, orgID, contactID, request.Actor)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return map[string]interface{}{"org_id": orgID, "contact_id": contactID, "released": released}, http.StatusOK, nil
+}
+
+// Merges another contact into this contact, which survives the merge. Options are which contact's fields and language
+// are kept when both have them, either "survivor" (the default), "loser" or "newest". With queue set, the merge is
+// queued as a task instead of done right away.
+//
+//   {
+//     "loser_id": 12346,
+//     "options": {"fields": "newest", "language": "survivor"},
+//     "queue": false
+//   }
+//
+type mergeRequest struct {
+	LoserID models.ContactID    `json:"loser_id" validate:"required"`
+	Options models.MergeOptions `json:"options"`
+	Queue   bool                `json:"queue"`
+}
+
+func handleMerge(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &mergeRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	orgID, contactID := contactFromURL(r)
+	if request.LoserID == contactID {
+		return nil, http.StatusBadRequest, errors.Errorf("can't merge contact into itself")
+	}
+
+	task := &contacts.MergeTask{SurvivorID: contactID, LoserID: request.LoserID, Options: request.Options}
+	response := map[string]interface{}{"org_id": orgID, "survivor_id": contactID, "loser_id": request.LoserID}
+
+	if request.Queue {
+		rc := s.RP.Get()
+		defer rc.Close()
+
+		err := contacts.QueueMerge(rc, orgID, task)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error queuing merge")
+		}
+
+		response["queued"] = true
+		return response, http.StatusOK, nil
+	}
+
+	err := contacts.Merge(ctx, s.DB, s.RP, orgID, task)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error merging contacts")
+	}
+
+	response["merged"] = true
+	return response, http.StatusOK, nil
+}
+
+// contactFromURL returns the org and contact ids from the passed in request's URL
+func contactFromURL(r *http.Request) (models.OrgID, models.ContactID) {
+	// our route patterns ensure these are valid
+	orgID, _ := strconv.Atoi(chi.URLParam(r, "org_id"))
+	contactID, _ := strconv.Atoi(chi.URLParam(r, "contact_id"))
+	return models.OrgID(orgID), models.ContactID(contactID)
+}
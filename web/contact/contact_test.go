@@ -0,0 +1,99 @@
+package contact
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/config"
+	"github.com/nyaruka/mailroom/handler"
+	"github.com/nyaruka/mailroom/locker"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	testsuite.ResetRP()
+	ctx := testsuite.CTX()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+	wg := &sync.WaitGroup{}
+
+	server := web.NewServer(ctx, config.Mailroom, nil, rp, nil, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	assert.NoError(t, handler.AddHandleTask(rc, models.CathyID, handler.NewTimeoutTask(models.Org1, models.CathyID, 1, time.Now())))
+	assert.NoError(t, handler.AddHandleTask(rc, models.CathyID, handler.NewTimeoutTask(models.Org1, models.CathyID, 2, time.Now())))
+
+	lock, err := locker.GrabLock(rp, models.ContactLock(models.Org1, models.CathyID), time.Minute, 0)
+	assert.NoError(t, err)
+	assert.NotZero(t, lock)
+
+	tcs := []struct {
+		URL      string
+		Method   string
+		Body     string
+		Status   int
+		Response string
+	}{
+		{"/mr/contact/1/10000/events", "POST", "", 405, "illegal"},
+		{"/mr/contact/1/10000/events", "GET", "", 400, "missing actor"},
+		{"/mr/contact/1/10000/events?actor=bob", "GET", "", 200, `"locked": true`},
+		{"/mr/contact/1/10000/events?actor=bob", "GET", "", 200, `"type": "timeout_event"`},
+		{"/mr/contact/1/10000/events/drop", "POST", `{}`, 400, "request failed validation"},
+		{"/mr/contact/1/10000/events/drop", "POST", `{"index": 0, "type": "timeout_event"}`, 400, "request failed validation"},
+		{"/mr/contact/1/10000/events/drop", "POST", `{"index": -1, "type": "timeout_event", "actor": "bob"}`, 400, "index can't be negative"},
+		{"/mr/contact/1/10000/events/drop", "POST", `{"index": 0, "type": "msg_event", "actor": "bob"}`, 404, "no msg_event event at index 0"},
+		{"/mr/contact/1/10000/events/drop", "POST", `{"index": 0, "type": "timeout_event", "actor": "bob"}`, 200, `"dropped": true`},
+		{"/mr/contact/1/10000/events?actor=bob", "GET", "", 200, `"session_id": 2`},
+		{"/mr/contact/1/10000/unlock", "POST", `{}`, 400, "request failed validation"},
+		{"/mr/contact/1/10000/unlock", "POST", `{"actor": "bob"}`, 200, `"released": true`},
+		{"/mr/contact/1/10000/events?actor=bob", "GET", "", 200, `"locked": false`},
+		{"/mr/contact/1/10000/unlock", "POST", `{"actor": "bob"}`, 200, `"released": false`},
+		{"/mr/contact/1/10000/merge", "POST", `{}`, 400, "request failed validation"},
+		{"/mr/contact/1/10000/merge", "POST", `{"loser_id": 10000}`, 400, "can't merge contact into itself"},
+		{"/mr/contact/1/10000/merge", "POST", `{"loser_id": 10001, "options": {"fields": "oldest"}}`, 400, "request failed validation"},
+		{"/mr/contact/1/10000/merge", "POST", `{"loser_id": 10001, "options": {"fields": "newest"}, "queue": true}`, 200, `"queued": true`},
+	}
+
+	for i, tc := range tcs {
+		var body io.Reader
+
+		if tc.Body != "" {
+			body = bytes.NewReader([]byte(tc.Body))
+		}
+
+		req, err := http.NewRequest(tc.Method, "http://localhost:8090"+tc.URL, body)
+		assert.NoError(t, err, "%d: error creating request", i)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err, "%d: error making request", i)
+
+		assert.Equal(t, tc.Status, resp.StatusCode, "%d: unexpected status", i)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "%d: error reading body", i)
+
+		assert.True(t, strings.Contains(string(content), tc.Response), "%d: did not find string: %s in body: %s", i, tc.Response, string(content))
+	}
+
+	// our queued merge should be waiting in the batch queue
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, queue.MergeContacts, task.Type)
+	assert.Equal(t, int(models.Org1), task.OrgID)
+}
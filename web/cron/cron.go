@@ -0,0 +1,52 @@
+package cron
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/cron"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodGet, "/mr/cron", web.RequireAuthToken(handleStatus))
+}
+
+// Returns the state of each cron registered in this process, what happened the last time this process tried to
+// fire it, and the last run of it by any process.
+//
+//   {
+//     "crons": [{
+//       "name": "campaign_event",
+//       "interval": 60,
+//       "started_on": "2019-02-05T20:30:00.000000Z",
+//       "last_fire": "2019-02-05T20:33:01.000000Z",
+//       "last_duration": 0.52,
+//       "lock_skipped": false,
+//       "last_run": {
+//         "name": "campaign_event",
+//         "last_start": "2019-02-05T20:33:01.000000Z",
+//         "last_finish": "2019-02-05T20:33:01.520000Z",
+//         "last_duration": 0.52,
+//         "last_outcome": "success"
+//       },
+//       "overdue": false
+//     }]
+//   }
+//
+type statusResponse struct {
+	Crons []*cron.Info `json:"crons"`
+}
+
+func handleStatus(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	rc := s.RP.Get()
+	defer rc.Close()
+
+	crons, err := cron.GetInfos(rc)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error getting cron statuses")
+	}
+
+	return &statusResponse{Crons: crons}, http.StatusOK, nil
+}
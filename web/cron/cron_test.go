@@ -0,0 +1,65 @@
+package cron
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/config"
+	"github.com/nyaruka/mailroom/cron"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	testsuite.ResetRP()
+	ctx := testsuite.CTX()
+	rp := testsuite.RP()
+	wg := &sync.WaitGroup{}
+
+	quit := make(chan bool)
+	defer close(quit)
+	cron.StartCron(quit, rp, "test_cron", time.Minute, func(ctx context.Context, lockName string, lockValue string) error {
+		return nil
+	})
+
+	server := web.NewServer(ctx, config.Mailroom, nil, rp, nil, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	tcs := []struct {
+		URL      string
+		Method   string
+		Status   int
+		Response string
+	}{
+		{"/mr/cron", "POST", 405, "illegal"},
+		{"/mr/cron", "GET", 200, `"name": "test_cron"`},
+		{"/mr/cron", "GET", 200, `"last_outcome": "success"`},
+		{"/mr/cron", "GET", 200, `"overdue": false`},
+	}
+
+	for i, tc := range tcs {
+		req, err := http.NewRequest(tc.Method, "http://localhost:8090"+tc.URL, nil)
+		assert.NoError(t, err, "%d: error creating request", i)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err, "%d: error making request", i)
+
+		assert.Equal(t, tc.Status, resp.StatusCode, "%d: unexpected status", i)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "%d: error reading body", i)
+
+		assert.True(t, strings.Contains(string(content), tc.Response), "%d: did not find string: %s in body: %s", i, tc.Response, string(content))
+	}
+}
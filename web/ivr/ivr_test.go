@@ -71,7 +71,7 @@ func TestTwilioIVR(t *testing.T) {
 	models.InsertFlowStarts(ctx, db, []*models.FlowStart{start})
 
 	// call our master starter
-	err := starts.CreateFlowBatches(ctx, db, rp, start)
+	err := starts.CreateFlowBatches(ctx, db, rp, queue.NewRedisBackend(rp), start)
 	assert.NoError(t, err)
 
 	// start our task
@@ -370,7 +370,7 @@ func TestNexmoIVR(t *testing.T) {
 	models.InsertFlowStarts(ctx, db, []*models.FlowStart{start})
 
 	// call our master starter
-	err := starts.CreateFlowBatches(ctx, db, rp, start)
+	err := starts.CreateFlowBatches(ctx, db, rp, queue.NewRedisBackend(rp), start)
 	assert.NoError(t, err)
 
 	// start our task
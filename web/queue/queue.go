@@ -0,0 +1,296 @@
+package queue
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodGet, "/mr/queue/{queue:[a-z_]+}", web.RequireAuthToken(handleInspect))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/queue/{queue:[a-z_]+}/pause", web.RequireAuthToken(handlePause))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/queue/{queue:[a-z_]+}/resume", web.RequireAuthToken(handleResume))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/queue/{queue:[a-z_]+}/dead_letters", web.RequireAuthToken(handleDeadLetters))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/queue/{queue:[a-z_]+}/dead_letters/requeue", web.RequireAuthToken(handleRequeueDeadLetter))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/queue/{queue:[a-z_]+}/dead_letters/purge", web.RequireAuthToken(handlePurgeDeadLetters))
+}
+
+// Inspects the current state of a queue, returning totals and a breakdown for each org with tasks queued or
+// being worked on. The oldest time is when the next task to be popped for the org was queued, and task types
+// only counts the next 100 tasks for each org.
+//
+//   {
+//     "queue": "batch",
+//     "size": 12,
+//     "delayed": 0,
+//     "inflight": 2,
+//     "orgs": [{
+//       "org_id": 1,
+//       "size": 12,
+//       "workers": 2,
+//       "paused": false,
+//       "oldest": "2019-02-05T20:33:10.123456Z",
+//       "oldest_age": 3.5,
+//       "task_types": {"start_flow_batch": 12}
+//     }]
+//   }
+//
+type inspectResponse struct {
+	Queue    string      `json:"queue"`
+	Size     int         `json:"size"`
+	Delayed  int         `json:"delayed"`
+	Inflight int         `json:"inflight"`
+	Orgs     []*orgQueue `json:"orgs"`
+}
+
+type orgQueue struct {
+	*queue.OrgQueue
+	OldestAge float64 `json:"oldest_age,omitempty"`
+}
+
+func handleInspect(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	name, err := queueFromURL(s, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	queues := queue.Default(s.RP)
+
+	orgQueues, err := queues.OrgQueues(name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error inspecting queue")
+	}
+
+	delayed, err := queues.DelayedSize(name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	inflight, err := queues.InflightSize(name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	response := &inspectResponse{
+		Queue:    name,
+		Delayed:  delayed,
+		Inflight: inflight,
+		Orgs:     make([]*orgQueue, len(orgQueues)),
+	}
+
+	for i, q := range orgQueues {
+		response.Size += q.Size
+		response.Orgs[i] = &orgQueue{OrgQueue: q}
+		if q.Oldest != nil {
+			response.Orgs[i].OldestAge = float64(time.Since(*q.Oldest)) / float64(time.Second)
+		}
+	}
+
+	return response, http.StatusOK, nil
+}
+
+// Pauses or resumes the tasks for a single org in a queue. Paused tasks remain queued until the org is resumed.
+//
+//   {
+//     "org_id": 1
+//   }
+//
+type orgRequest struct {
+	OrgID int `json:"org_id" validate:"required"`
+}
+
+func handlePause(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	return handlePauseOrResume(ctx, s, r, true)
+}
+
+func handleResume(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	return handlePauseOrResume(ctx, s, r, false)
+}
+
+func handlePauseOrResume(ctx context.Context, s *web.Server, r *http.Request, pause bool) (interface{}, int, error) {
+	request := &orgRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	name, err := queueFromURL(s, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	queues := queue.Default(s.RP)
+
+	if pause {
+		err = queues.PauseOrg(name, request.OrgID)
+	} else {
+		err = queues.ResumeOrg(name, request.OrgID)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	logrus.WithField("queue", name).WithField("org_id", request.OrgID).WithField("paused", pause).Info("org queue paused state changed")
+
+	return map[string]interface{}{"queue": name, "org_id": request.OrgID, "paused": pause}, http.StatusOK, nil
+}
+
+// Lists the dead letters for a single org in a queue, newest first. Count defaults to 50.
+//
+//   {
+//     "org_id": 1,
+//     "offset": 0,
+//     "count": 50
+//   }
+//
+type deadLettersRequest struct {
+	OrgID  int `json:"org_id" validate:"required"`
+	Offset int `json:"offset" validate:"min=0"`
+	Count  int `json:"count"  validate:"min=0,max=1000"`
+}
+
+// Our response for a dead letter listing
+//
+//   {
+//     "queue": "handler",
+//     "org_id": 1,
+//     "total": 1,
+//     "dead_letters": [{
+//       "id": "8cbe5ba8-4fc3-4a73-9dd4-2e2ad3a6b5f2",
+//       "queue": "handler",
+//       "task": {"type": "handle_contact_event", "org_id": 1, "task": {...}, "queued_on": "...", "error_count": 3},
+//       "error": "error handling contact event",
+//       "failed_on": "2019-02-05T20:33:10.123456Z"
+//     }]
+//   }
+//
+type deadLettersResponse struct {
+	Queue       string              `json:"queue"`
+	OrgID       int                 `json:"org_id"`
+	Total       int                 `json:"total"`
+	DeadLetters []*queue.DeadLetter `json:"dead_letters"`
+}
+
+func handleDeadLetters(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &deadLettersRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+	if request.Count == 0 {
+		request.Count = 50
+	}
+
+	name, err := queueFromURL(s, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	queues := queue.Default(s.RP)
+
+	total, err := queues.DeadLetterCount(name, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	letters, err := queues.DeadLetters(name, request.OrgID, request.Offset, request.Count)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &deadLettersResponse{Queue: name, OrgID: request.OrgID, Total: total, DeadLetters: letters}, http.StatusOK, nil
+}
+
+// Requeues a single dead letter, adding its task back to the queue it failed in with its errors reset.
+//
+//   {
+//     "org_id": 1,
+//     "id": "8cbe5ba8-4fc3-4a73-9dd4-2e2ad3a6b5f2"
+//   }
+//
+type requeueRequest struct {
+	OrgID int    `json:"org_id" validate:"required"`
+	ID    string `json:"id"     validate:"required"`
+}
+
+func handleRequeueDeadLetter(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &requeueRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	name, err := queueFromURL(s, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	queues := queue.Default(s.RP)
+
+	letter, err := queues.RequeueDeadLetter(name, request.OrgID, request.ID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if letter == nil {
+		return nil, http.StatusNotFound, errors.Errorf("no such dead letter: %s", request.ID)
+	}
+
+	logrus.WithField("queue", name).WithField("org_id", request.OrgID).WithField("dead_letter_id", request.ID).Info("dead letter requeued")
+
+	return letter, http.StatusOK, nil
+}
+
+// Purges all the dead letters for a single org in a queue.
+//
+//   {
+//     "org_id": 1
+//   }
+//
+func handlePurgeDeadLetters(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &orgRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	name, err := queueFromURL(s, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	queues := queue.Default(s.RP)
+
+	count, err := queues.DeadLetterCount(name, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	err = queues.PurgeDeadLetters(name, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	logrus.WithField("queue", name).WithField("org_id", request.OrgID).WithField("purged", count).Info("dead letters purged")
+
+	return map[string]interface{}{"queue": name, "org_id": request.OrgID, "purged": count}, http.StatusOK, nil
+}
+
+// queueFromURL returns the name of the queue in the passed in request's URL, which must be one of our queues
+func queueFromURL(s *web.Server, r *http.Request) (string, error) {
+	name := chi.URLParam(r, "queue")
+	if name == queue.HandlerQueue || name == queue.BatchQueue {
+		return name, nil
+	}
+
+	named, err := s.Config.NamedQueues()
+	if err != nil {
+		return "", err
+	}
+	if _, found := named[name]; found {
+		return name, nil
+	}
+	return "", errors.Errorf("unknown queue: %s", name)
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/config"
+	"github.com/nyaruka/mailroom/queue"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	testsuite.ResetRP()
+	ctx := testsuite.CTX()
+	rp := testsuite.RP()
+	rc := testsuite.RC()
+	defer rc.Close()
+	wg := &sync.WaitGroup{}
+
+	server := web.NewServer(ctx, config.Mailroom, nil, rp, nil, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	assert.NoError(t, queue.AddTask(rc, queue.BatchQueue, queue.StartFlowBatch, 1, "task1", queue.DefaultPriority))
+	assert.NoError(t, queue.AddTask(rc, queue.BatchQueue, queue.SendBroadcastBatch, 1, "task2", queue.DefaultPriority))
+
+	letter1, err := queue.AddDeadLetter(rc, queue.HandlerQueue, &queue.Task{Type: queue.HandleContactEvent, OrgID: 1, Task: json.RawMessage(`"task3"`), ErrorCount: 3}, "boom", "")
+	assert.NoError(t, err)
+	_, err = queue.AddDeadLetter(rc, queue.HandlerQueue, &queue.Task{Type: queue.HandleContactEvent, OrgID: 1, Task: json.RawMessage(`"task4"`), ErrorCount: 3}, "bang", "")
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		URL      string
+		Method   string
+		Body     string
+		Status   int
+		Response string
+	}{
+		{"/mr/queue/batch", "POST", "", 405, "illegal"},
+		{"/mr/queue/bacth", "GET", "", 400, "unknown queue: bacth"},
+		{"/mr/queue/bacth/pause", "POST", `{"org_id": 1}`, 400, "unknown queue: bacth"},
+		{"/mr/queue/ivr", "GET", "", 200, `"queue": "ivr"`},
+		{"/mr/queue/batch", "GET", "", 200, `"size": 2`},
+		{"/mr/queue/batch", "GET", "", 200, `"send_broadcast_batch": 1`},
+		{"/mr/queue/batch/pause", "POST", `{}`, 400, "request failed validation"},
+		{"/mr/queue/batch/pause", "POST", `{"org_id": 1}`, 200, `"paused": true`},
+		{"/mr/queue/batch", "GET", "", 200, `"paused": true`},
+		{"/mr/queue/batch/resume", "POST", `{"org_id": 1}`, 200, `"paused": false`},
+		{"/mr/queue/batch", "GET", "", 200, `"paused": false`},
+		{"/mr/queue/handler/dead_letters", "POST", `{}`, 400, "request failed validation"},
+		{"/mr/queue/bacth/dead_letters", "POST", `{"org_id": 1}`, 400, "unknown queue: bacth"},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 1}`, 200, `"total": 2`},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 1, "count": 1}`, 200, `"error": "bang"`},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 1, "offset": 1}`, 200, `"error": "boom"`},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 2}`, 200, `"dead_letters": []`},
+		{"/mr/queue/handler/dead_letters/requeue", "POST", `{"org_id": 1, "id": "bad"}`, 404, "no such dead letter: bad"},
+		{"/mr/queue/handler/dead_letters/requeue", "POST", fmt.Sprintf(`{"org_id": 1, "id": "%s"}`, letter1.ID), 200, `"error": "boom"`},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 1}`, 200, `"total": 1`},
+		{"/mr/queue/handler/dead_letters/purge", "POST", `{"org_id": 1}`, 200, `"purged": 1`},
+		{"/mr/queue/handler/dead_letters", "POST", `{"org_id": 1}`, 200, `"total": 0`},
+	}
+
+	for i, tc := range tcs {
+		var body io.Reader
+
+		if tc.Body != "" {
+			body = bytes.NewReader([]byte(tc.Body))
+		}
+
+		req, err := http.NewRequest(tc.Method, "http://localhost:8090"+tc.URL, body)
+		assert.NoError(t, err, "%d: error creating request", i)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err, "%d: error making request", i)
+
+		assert.Equal(t, tc.Status, resp.StatusCode, "%d: unexpected status", i)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "%d: error reading body", i)
+
+		assert.True(t, strings.Contains(string(content), tc.Response), "%d: did not find string: %s in body: %s", i, tc.Response, string(content))
+	}
+
+	// our org should be resumed and its tasks poppable
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	assert.NoError(t, err)
+	assert.NotNil(t, task)
+
+	// and our requeued dead letter should be back in its queue with its errors reset
+	task, err = queue.PopNextTask(rc, queue.HandlerQueue)
+	assert.NoError(t, err)
+	assert.Equal(t, `"task3"`, string(task.Task))
+	assert.Equal(t, 0, task.ErrorCount)
+}
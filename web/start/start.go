@@ -0,0 +1,51 @@
+package start
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/start/cancel", web.RequireAuthToken(handleCancel))
+}
+
+// Cancels a flow start which is pending or in progress. Any batches which haven't yet been started
+// will be skipped, batches already being started will run to completion.
+//
+//   {
+//     "org_id": 1,
+//     "start_id": 12345
+//   }
+//
+type cancelRequest struct {
+	OrgID   models.OrgID   `json:"org_id"   validate:"required"`
+	StartID models.StartID `json:"start_id" validate:"required"`
+}
+
+func handleCancel(ctx context.Context, s *web.Server, r *http.Request) (interface{}, int, error) {
+	request := &cancelRequest{}
+	if err := utils.UnmarshalAndValidateWithLimit(r.Body, request, web.MaxRequestBytes); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrapf(err, "request failed validation")
+	}
+
+	rc := s.RP.Get()
+	defer rc.Close()
+
+	cancelled, err := models.CancelFlowStart(ctx, s.DB, rc, request.OrgID, request.StartID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !cancelled {
+		return nil, http.StatusNotFound, errors.Errorf("no pending or in progress start with id: %d", request.StartID)
+	}
+
+	logrus.WithField("org_id", request.OrgID).WithField("start_id", request.StartID).Info("flow start cancelled")
+
+	return map[string]interface{}{"start_id": request.StartID, "cancelled": true}, http.StatusOK, nil
+}
@@ -0,0 +1,79 @@
+package start
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/config"
+	"github.com/nyaruka/mailroom/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	ctx, db, rp := testsuite.Reset()
+	wg := &sync.WaitGroup{}
+
+	server := web.NewServer(ctx, config.Mailroom, db, rp, nil, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	var startID models.StartID
+	err := db.Get(&startID,
+		`INSERT INTO flows_flowstart(is_active, created_on, modified_on, uuid, restart_participants, include_active, contact_count, status, flow_id, created_by_id, modified_by_id)
+		 VALUES(TRUE, NOW(), NOW(), $1, TRUE, TRUE, 2, 'S', $2, 1, 1) RETURNING id`, utils.NewUUID(), models.SingleMessageFlowID)
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		URL      string
+		Method   string
+		Body     string
+		Status   int
+		Response string
+	}{
+		{"/mr/start/cancel", "GET", "", 405, "illegal"},
+		{"/mr/start/cancel", "POST", `{"org_id": 1}`, 400, "request failed validation"},
+		{"/mr/start/cancel", "POST", `{"org_id": 2, "start_id": %d}`, 404, "no pending or in progress start"},
+		{"/mr/start/cancel", "POST", `{"org_id": 1, "start_id": %d}`, 200, `"cancelled": true`},
+		{"/mr/start/cancel", "POST", `{"org_id": 1, "start_id": %d}`, 404, "no pending or in progress start"},
+	}
+
+	for i, tc := range tcs {
+		// fill in the id of our start
+		body := strings.Replace(tc.Body, "%d", fmt.Sprint(startID), 1)
+
+		req, err := http.NewRequest(tc.Method, "http://localhost:8090"+tc.URL, bytes.NewReader([]byte(body)))
+		assert.NoError(t, err, "%d: error creating request", i)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err, "%d: error making request", i)
+
+		assert.Equal(t, tc.Status, resp.StatusCode, "%d: unexpected status", i)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "%d: error reading body", i)
+
+		assert.True(t, strings.Contains(string(content), tc.Response), "%d: did not find string: %s in body: %s", i, tc.Response, string(content))
+	}
+
+	testsuite.AssertQueryCount(t, db, `SELECT count(*) FROM flows_flowstart WHERE id = $1 AND status = 'I'`, []interface{}{startID}, 1)
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	cancelled, err := models.IsFlowStartCancelled(rc, startID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+}
@@ -2,52 +2,159 @@ package mailroom
 
 import (
 	"context"
+	"fmt"
 	"runtime/debug"
+	"sync"
 	"time"
 
+	"github.com/nyaruka/librato"
+	"github.com/nyaruka/mailroom/cron"
+	"github.com/nyaruka/mailroom/models"
 	"github.com/nyaruka/mailroom/queue"
 	"github.com/sirupsen/logrus"
 )
 
+// orgMaxWorkersConfig is the org config key which overrides the maximum number of workers handling tasks for that org
+const orgMaxWorkersConfig = "max_concurrent_workers"
+
 // Foreman takes care of managing our set of workers and assigns msgs for each to send
 type Foreman struct {
 	mr               *Mailroom
 	queue            string
+	minWorkers       int
+	maxWorkers       int
 	workers          []*Worker
+	workersMutex     sync.Mutex
+	nextWorkerID     int
 	availableWorkers chan *Worker
 	quit             chan bool
+
+	lastDBWaitCount int64
 }
 
-// NewForeman creates a new Foreman for the passed in server with the number of max workers
-func NewForeman(mr *Mailroom, queue string, maxWorkers int) *Foreman {
+const (
+	// scaleInterval is how often our foremen check whether they should add or remove workers
+	scaleInterval = time.Second * 5
+
+	// pollInterval is the longest an idle foreman waits before checking its queue again without a notification,
+	// this is how delayed tasks and tasks from orgs which were at their worker limit get picked up
+	pollInterval = time.Second
+)
+
+// NewForeman creates a new Foreman for the passed in server which will scale between the passed in minimum
+// and maximum number of workers
+func NewForeman(mr *Mailroom, queue string, minWorkers int, maxWorkers int) *Foreman {
+	if minWorkers < 1 {
+		minWorkers = 1
+	}
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
+	}
+
 	foreman := &Foreman{
 		mr:               mr,
 		queue:            queue,
-		workers:          make([]*Worker, maxWorkers),
+		minWorkers:       minWorkers,
+		maxWorkers:       maxWorkers,
+		workers:          make([]*Worker, 0, maxWorkers),
 		availableWorkers: make(chan *Worker, maxWorkers),
 		quit:             make(chan bool),
 	}
 
-	for i := 0; i < maxWorkers; i++ {
-		foreman.workers[i] = NewWorker(foreman, i)
+	for i := 0; i < minWorkers; i++ {
+		foreman.addWorker()
 	}
 
 	return foreman
 }
 
+// addWorker creates a new worker and adds it to our pool, it is not started
+func (f *Foreman) addWorker() *Worker {
+	f.workersMutex.Lock()
+	defer f.workersMutex.Unlock()
+
+	worker := NewWorker(f, f.nextWorkerID)
+	f.nextWorkerID++
+	f.workers = append(f.workers, worker)
+	return worker
+}
+
+// removeWorker removes the passed in worker from our pool and stops it
+func (f *Foreman) removeWorker(worker *Worker) {
+	f.workersMutex.Lock()
+	defer f.workersMutex.Unlock()
+
+	for i, w := range f.workers {
+		if w == worker {
+			f.workers = append(f.workers[:i], f.workers[i+1:]...)
+			break
+		}
+	}
+	worker.Stop()
+}
+
+// workerCount returns the number of workers currently in our pool
+func (f *Foreman) workerCount() int {
+	f.workersMutex.Lock()
+	defer f.workersMutex.Unlock()
+	return len(f.workers)
+}
+
 // Start starts the foreman and all its workers, assigning jobs while there are some
 func (f *Foreman) Start() {
 	for _, worker := range f.workers {
 		worker.Start()
 	}
 	go f.Assign()
+
+	// requeue any tasks whose leases expired because the process working on them died
+	cron.StartCron(f.mr.Quit, f.mr.RP, fmt.Sprintf("reap_%s_leases", f.queue), time.Minute,
+		func(ctx context.Context, lockName string, lockValue string) error {
+			reaped, err := f.mr.Queue.ReapExpiredLeases(f.queue)
+			if err != nil {
+				return err
+			}
+			if reaped > 0 {
+				logrus.WithField("comp", "foreman").WithField("queue", f.queue).WithField("reaped", reaped).Warn("requeued tasks with expired leases")
+			}
+			return nil
+		},
+	)
+
+	// keep our org concurrency limits in sync with our config and org overrides
+	cron.StartCron(f.mr.Quit, f.mr.RP, fmt.Sprintf("sync_%s_limits", f.queue), time.Minute,
+		func(ctx context.Context, lockName string, lockValue string) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			return f.syncConcurrencyLimits(ctx)
+		},
+	)
+}
+
+// syncConcurrencyLimits writes the limits on concurrent workers per org for our queue, these are our configured
+// default and any overrides set in org configs
+func (f *Foreman) syncConcurrencyLimits(ctx context.Context) error {
+	overrides, err := models.LoadOrgIntConfigs(ctx, f.mr.DB, orgMaxWorkersConfig)
+	if err != nil {
+		return err
+	}
+
+	orgLimits := make(map[int]int, len(overrides))
+	for orgID, limit := range overrides {
+		orgLimits[int(orgID)] = limit
+	}
+
+	return f.mr.Queue.SetConcurrencyLimits(f.queue, f.mr.Config.OrgMaxWorkers, orgLimits)
 }
 
 // Stop stops the foreman and all its workers, the wait group of the worker can be used to track progress
 func (f *Foreman) Stop() {
+	f.workersMutex.Lock()
 	for _, worker := range f.workers {
 		worker.Stop()
 	}
+	f.workersMutex.Unlock()
+
 	close(f.quit)
 	logrus.WithField("comp", "foreman").WithField("state", "stopping").Info("foreman stopping")
 }
@@ -57,14 +164,19 @@ func (f *Foreman) Stop() {
 func (f *Foreman) Assign() {
 	f.mr.WaitGroup.Add(1)
 	defer f.mr.WaitGroup.Done()
-	log := logrus.WithField("comp", "foreman")
+	log := logrus.WithField("comp", "foreman").WithField("queue", f.queue)
 
 	log.WithFields(logrus.Fields{
-		"state":   "started",
-		"workers": len(f.workers),
-		"queue":   f.queue,
+		"state":       "started",
+		"workers":     f.workerCount(),
+		"min_workers": f.minWorkers,
+		"max_workers": f.maxWorkers,
 	}).Info("workers started and waiting")
 
+	notifications := f.mr.Queue.Notifications(f.queue, f.quit)
+	scaleTicker := time.NewTicker(scaleInterval)
+	defer scaleTicker.Stop()
+
 	lastSleep := false
 
 	for true {
@@ -74,33 +186,87 @@ func (f *Foreman) Assign() {
 			log.WithField("state", "stopped").Info("foreman stopped")
 			return
 
+		// periodically check whether we need more or fewer workers
+		case <-scaleTicker.C:
+			f.scale()
+
 		// otherwise, grab the next task and assign it to a worker
 		case worker := <-f.availableWorkers:
 			// see if we have a task to work on
-			rc := f.mr.RP.Get()
-			task, err := queue.PopNextTask(rc, f.queue)
-			rc.Close()
+			task, err := f.mr.Queue.PopNextTask(f.queue)
 
 			if err == nil && task != nil {
 				// if so, assign it to our worker
 				worker.job <- task
 				lastSleep = false
-			} else {
-				// we received an error getting the next message, log it
-				if err != nil {
-					log.WithError(err).Error("error popping task")
-				}
-
-				// add our worker back to our queue and sleep a bit
-				if !lastSleep {
-					log.Debug("sleeping, no tasks")
-					lastSleep = true
-				}
-				f.availableWorkers <- worker
-				time.Sleep(250 * time.Millisecond)
+				continue
+			}
+
+			// we received an error getting the next message, log it
+			if err != nil {
+				log.WithError(err).Error("error popping task")
+			}
+
+			// add our worker back to our queue and wait until a task is added or our poll interval passes
+			if !lastSleep {
+				log.Debug("sleeping, no tasks")
+				lastSleep = true
 			}
+			f.availableWorkers <- worker
+
+			select {
+			case <-f.quit:
+			case <-notifications:
+			case <-scaleTicker.C:
+				f.scale()
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+}
+
+// scale adds workers if all of ours are busy and there are still tasks queued, as long as our DB isn't already
+// making connections wait, and removes an idle worker if our queue is empty
+func (f *Foreman) scale() {
+	log := logrus.WithField("comp", "foreman").WithField("queue", f.queue)
+
+	size, err := f.mr.Queue.Size(f.queue)
+	if err != nil {
+		log.WithError(err).Error("error getting queue size")
+		return
+	}
+
+	// have callers been waiting for DB connections since we last checked?
+	dbWaitCount := f.mr.DB.Stats().WaitCount
+	dbWaiting := dbWaitCount > f.lastDBWaitCount
+	f.lastDBWaitCount = dbWaitCount
+
+	workers := f.workerCount()
+	idle := len(f.availableWorkers)
+
+	if size > 0 && idle == 0 && workers < f.maxWorkers && !dbWaiting {
+		added := size
+		if added > f.maxWorkers-workers {
+			added = f.maxWorkers - workers
+		}
+		for i := 0; i < added; i++ {
+			f.addWorker().Start()
+		}
+		workers += added
+		log.WithField("added", added).WithField("workers", workers).WithField("size", size).Info("scaled up workers")
+
+	} else if (size == 0 || dbWaiting) && idle > 0 && workers > f.minWorkers {
+		// remove a single idle worker each time, we scale down gradually
+		select {
+		case worker := <-f.availableWorkers:
+			f.removeWorker(worker)
+			workers--
+			log.WithField("workers", workers).WithField("size", size).WithField("db_waiting", dbWaiting).Info("scaled down workers")
+		default:
 		}
 	}
+
+	librato.Gauge(fmt.Sprintf("mr.%s_workers", f.queue), float64(workers))
 }
 
 // Worker is our type for a single goroutine that is handling queued events
@@ -162,17 +328,22 @@ func (w *Worker) handleTask(task *queue.Task) {
 		if panicLog != nil {
 			debug.PrintStack()
 			log.WithField("task", string(task.Task)).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Errorf("panic handling task: %s", panicLog)
+			task.ErrorCount++
+			w.addDeadLetter(task, fmt.Sprintf("panic handling task: %s", panicLog), string(debug.Stack()))
 		}
 
 		// mark our task as complete
-		rc := w.foreman.mr.RP.Get()
-		err := queue.MarkTaskComplete(rc, w.foreman.queue, task.OrgID)
+		err := w.foreman.mr.Queue.MarkTaskComplete(w.foreman.queue, task)
 		if err != nil {
-			log.WithError(err)
+			log.WithError(err).Error("error marking task complete")
 		}
-		rc.Close()
 	}()
 
+	// keep our lease on this task for as long as we are working on it
+	done := make(chan bool)
+	defer close(done)
+	go w.extendLease(task, done)
+
 	log.Info("starting handling of task")
 	start := time.Now()
 
@@ -180,11 +351,57 @@ func (w *Worker) handleTask(task *queue.Task) {
 	if found {
 		err := taskFunc(context.Background(), w.foreman.mr, task)
 		if err != nil {
-			log.WithError(err).WithField("task", string(task.Task)).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Error("error running task")
+			task.ErrorCount++
+			log.WithError(err).WithField("task", string(task.Task)).WithField("task_type", task.Type).WithField("org_id", task.OrgID).WithField("error_count", task.ErrorCount).Error("error running task")
+
+			// retry if our policy allows it, otherwise this is a permanent failure
+			policy := retryPolicies[task.Type]
+			if policy.ShouldRetry(task, err) {
+				w.retryTask(task, policy.Backoff(task.ErrorCount))
+			} else {
+				w.addDeadLetter(task, err.Error(), fmt.Sprintf("%+v", err))
+			}
 		}
 	} else {
 		log.Error("unable to find function for task type")
+		task.ErrorCount++
+		w.addDeadLetter(task, "unable to find function for task type", "")
 	}
 
 	log.WithField("elapsed", time.Since(start)).Info("task complete")
 }
+
+// extendLease periodically extends our lease on the passed in task until done is closed
+func (w *Worker) extendLease(task *queue.Task, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-time.After(queue.LeaseDuration / 3):
+			err := w.foreman.mr.Queue.ExtendTaskLease(w.foreman.queue, task, queue.LeaseDuration)
+			if err != nil {
+				logrus.WithError(err).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Error("error extending task lease")
+			}
+		}
+	}
+}
+
+// retryTask queues the passed in failed task to be tried again after the passed in backoff
+func (w *Worker) retryTask(task *queue.Task, backoff time.Duration) {
+	err := w.foreman.mr.Queue.RetryTask(w.foreman.queue, task, time.Now().Add(backoff))
+	if err != nil {
+		logrus.WithError(err).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Error("error queuing task for retry")
+		return
+	}
+
+	logrus.WithField("task_type", task.Type).WithField("org_id", task.OrgID).WithField("error_count", task.ErrorCount).WithField("backoff", backoff).Info("task queued for retry")
+}
+
+// addDeadLetter records the passed in task as permanently failed so that it can be inspected and requeued later
+func (w *Worker) addDeadLetter(task *queue.Task, errMsg string, stack string) {
+	_, err := w.foreman.mr.Queue.AddDeadLetter(w.foreman.queue, task, errMsg, stack)
+	if err != nil {
+		logrus.WithError(err).WithField("task_type", task.Type).WithField("org_id", task.OrgID).Error("error adding dead letter for task")
+	}
+}